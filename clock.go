@@ -1,6 +1,20 @@
 package pacemaker
 
-import "time"
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timer is the clock agnostic counterpart of time.Timer
+type Timer interface {
+	// C returns the channel the current time is delivered to once the timer fires
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. Returns false if it already fired or was stopped.
+	Stop() bool
+	// Reset changes the timer to fire after duration d. Returns true if the timer was pending.
+	Reset(d time.Duration) bool
+}
 
 type RealClock struct{}
 
@@ -8,22 +22,228 @@ func (c RealClock) Now() time.Time {
 	return time.Now()
 }
 
+// After waits for the duration to elapse and then sends the current time on the returned channel
+func (c RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTimer creates a new Timer that will send the current time on its channel after at least duration d
+func (c RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+// Sleep pauses the current goroutine for at least the duration d
+func (c RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
 func NewClock() *RealClock {
 	return &RealClock{}
 }
 
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
+
+// TestClock is a virtual clock whose time only passes when Forward is called. Timers and sleepers created from
+// it fire deterministically as soon as Forward reaches their deadline, which allows testing code that blocks
+// without real sleeps. Copies of a TestClock share its time and timers.
 type TestClock struct {
-	now time.Time
+	state *testClockState
+}
+
+type testClockState struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*testTimer
 }
 
 func (c TestClock) Now() time.Time {
-	return c.now
+	if c.state == nil {
+		return time.Time{}
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return c.state.now
 }
 
+// Forward moves the clock forward by duration, firing every timer whose deadline was reached, earliest first
 func (c *TestClock) Forward(duration time.Duration) {
-	c.now = c.now.Add(duration)
+	s := c.init()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = s.now.Add(duration)
+
+	var (
+		pending []*testTimer
+		fired   []*testTimer
+	)
+
+	for _, t := range s.timers {
+		if t.deadline.After(s.now) {
+			pending = append(pending, t)
+		} else {
+			fired = append(fired, t)
+		}
+	}
+
+	s.timers = pending
+
+	sort.SliceStable(fired, func(i, j int) bool {
+		return fired[i].deadline.Before(fired[j].deadline)
+	})
+
+	for _, t := range fired {
+		t.fire(s.now)
+	}
+
+	s.condition().Broadcast()
+}
+
+// After waits for the clock to be forwarded by at least duration d and then sends the current time on the
+// returned channel
+func (c *TestClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer creates a new Timer that fires once the clock has been forwarded by at least duration d
+func (c *TestClock) NewTimer(d time.Duration) Timer {
+	s := c.init()
+
+	t := &testTimer{
+		clock: s,
+		ch:    make(chan time.Time, 1),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.schedule(t, d)
+
+	return t
+}
+
+// Sleep blocks until the clock has been forwarded by at least duration d
+func (c *TestClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Waiters returns the amount of timers and sleepers pending to be fired
+func (c *TestClock) Waiters() int {
+	s := c.init()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.timers)
+}
+
+// BlockUntil blocks until at least n timers or sleepers are waiting on the clock. Typically used to make sure the
+// goroutines under test are blocked before calling Forward.
+func (c *TestClock) BlockUntil(n int) {
+	s := c.init()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.timers) < n {
+		s.condition().Wait()
+	}
+}
+
+// init returns the state of the clock, allocating it for zero value clocks
+func (c *TestClock) init() *testClockState {
+	if c.state == nil {
+		c.state = &testClockState{}
+	}
+	return c.state
+}
+
+// condition must be called with the lock held
+func (s *testClockState) condition() *sync.Cond {
+	if s.cond == nil {
+		s.cond = sync.NewCond(&s.mu)
+	}
+	return s.cond
+}
+
+// schedule must be called with the lock held
+func (s *testClockState) schedule(t *testTimer, d time.Duration) {
+	t.deadline = s.now.Add(d)
+
+	if d <= 0 {
+		t.fire(s.now)
+		return
+	}
+
+	s.timers = append(s.timers, t)
+	s.condition().Broadcast()
+}
+
+// unschedule must be called with the lock held
+func (s *testClockState) unschedule(t *testTimer) bool {
+	for i, pending := range s.timers {
+		if pending == t {
+			s.timers = append(s.timers[:i], s.timers[i+1:]...)
+			s.condition().Broadcast()
+			return true
+		}
+	}
+
+	return false
 }
 
 func NewMockClock(startAt time.Time) *TestClock {
-	return &TestClock{now: startAt}
+	return &TestClock{state: &testClockState{now: startAt}}
+}
+
+type testTimer struct {
+	clock    *testClockState
+	ch       chan time.Time
+	deadline time.Time
+}
+
+func (t *testTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *testTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.clock.unschedule(t)
+}
+
+func (t *testTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasPending := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+
+	return wasPending
+}
+
+func (t *testTimer) fire(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
 }
@@ -0,0 +1,134 @@
+package pacemaker
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	testClockStart = time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC)
+)
+
+func assertNotFired(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+	select {
+	case ts := <-ch:
+		t.Fatalf("unexpected timer fired at %v", ts)
+	default:
+	}
+}
+
+func assertFiredAt(t *testing.T, ch <-chan time.Time, expected time.Time) {
+	t.Helper()
+	select {
+	case ts := <-ch:
+		if !ts.Equal(expected) {
+			t.Errorf("unexpected fire time, want %v, have %v", expected, ts)
+		}
+	default:
+		t.Fatalf("expected timer to have fired")
+	}
+}
+
+func TestTestClock_After(t *testing.T) {
+	clock := NewMockClock(testClockStart)
+
+	ch := clock.After(time.Second * 10)
+
+	clock.Forward(time.Second * 9)
+	assertNotFired(t, ch)
+
+	clock.Forward(time.Second * 2)
+	assertFiredAt(t, ch, testClockStart.Add(time.Second*11))
+
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("unexpected waiters, want 0, have %d", n)
+	}
+}
+
+func TestTestClock_AfterNonPositiveFiresImmediately(t *testing.T) {
+	clock := NewMockClock(testClockStart)
+
+	assertFiredAt(t, clock.After(0), testClockStart)
+	assertFiredAt(t, clock.After(-time.Second), testClockStart)
+}
+
+func TestTestClock_TimerStopAndReset(t *testing.T) {
+	clock := NewMockClock(testClockStart)
+
+	timer := clock.NewTimer(time.Second * 5)
+
+	if !timer.Stop() {
+		t.Errorf("expected stop of pending timer to return true")
+	}
+
+	if timer.Stop() {
+		t.Errorf("expected stop of stopped timer to return false")
+	}
+
+	clock.Forward(time.Second * 5)
+	assertNotFired(t, timer.C())
+
+	if timer.Reset(time.Second * 3) {
+		t.Errorf("expected reset of stopped timer to return false")
+	}
+
+	clock.Forward(time.Second * 2)
+	assertNotFired(t, timer.C())
+
+	if !timer.Reset(time.Second * 3) {
+		t.Errorf("expected reset of pending timer to return true")
+	}
+
+	clock.Forward(time.Second * 2)
+	assertNotFired(t, timer.C())
+
+	clock.Forward(time.Second)
+	assertFiredAt(t, timer.C(), testClockStart.Add(time.Second*10))
+}
+
+func TestTestClock_SleepersWakeUpOnDeadline(t *testing.T) {
+	clock := NewMockClock(testClockStart)
+
+	durations := []time.Duration{time.Second * 3, time.Second, time.Second * 2}
+	woken := make(chan time.Duration, len(durations))
+
+	for _, d := range durations {
+		go func(d time.Duration) {
+			clock.Sleep(d)
+			woken <- d
+		}(d)
+	}
+
+	clock.BlockUntil(len(durations))
+
+	clock.Forward(time.Second * 2)
+
+	first, second := <-woken, <-woken
+	if first+second != time.Second*3 {
+		t.Errorf("unexpected sleepers woken up, want 1s and 2s, have %v and %v", first, second)
+	}
+
+	if n := clock.Waiters(); n != 1 {
+		t.Errorf("unexpected waiters, want 1, have %d", n)
+	}
+
+	clock.Forward(time.Second)
+
+	if last := <-woken; last != time.Second*3 {
+		t.Errorf("unexpected sleeper woken up, want 3s, have %v", last)
+	}
+}
+
+func TestTestClock_Value(t *testing.T) {
+	mock := NewMockClock(testClockStart)
+
+	// A TestClock stored by value is still a clock, sharing the time of the one it was copied from
+	var c clock = *mock
+
+	mock.Forward(time.Second)
+
+	if now := c.Now(); !now.Equal(testClockStart.Add(time.Second)) {
+		t.Errorf("unexpected now, want %v, have %v", testClockStart.Add(time.Second), now)
+	}
+}
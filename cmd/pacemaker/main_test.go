@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestMigrate_RedisToFile(t *testing.T) {
+	ctx := context.Background()
+	srv := pacemakertest.NewRedis(t)
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	window := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+
+	src := pacemaker.NewFixedWindowRedisStorage(srv.Client, pacemaker.FixedWindowRedisStorageOpts{Prefix: "bot"})
+
+	_, _ = src.Inc(ctx, pacemaker.FixedWindowIncArgs{
+		Window: window, TTL: time.Minute, Tokens: 4, Capacity: 10,
+	})
+
+	from := "redis://" + srv.Addr() + "/0?prefix=bot"
+	to := "file://" + path
+
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+		expectedFile   bool
+	}{
+		{
+			name:           "dry run",
+			args:           []string{"migrate", "-from", from, "-to", to, "-dry-run"},
+			expectedOutput: "dry run: 1 windows would be migrated",
+			expectedFile:   false,
+		},
+		{
+			name:           "migrate",
+			args:           []string{"migrate", "-from", from, "-to", to},
+			expectedOutput: "1 windows migrated",
+			expectedFile:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			if err := run(ctx, test.args, &stdout, &stderr); err != nil {
+				t.Fatalf("unexpected error, want none, have %v: %s", err, stderr.String())
+			}
+
+			if !strings.Contains(stdout.String(), test.expectedOutput) {
+				t.Errorf("unexpected output, want %q, have %q", test.expectedOutput, stdout.String())
+			}
+
+			if _, err := os.Stat(path); (err == nil) != test.expectedFile {
+				t.Errorf("unexpected snapshot file, want exists=%v, have error %v", test.expectedFile, err)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	dst := pacemaker.NewFixedWindowMemoryStorage()
+	if err := dst.Restore(ctx, data); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if c, _ := dst.Get(ctx, window); c != 4 {
+		t.Errorf("unexpected counter, want 4, have %d", c)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expectedErr error
+	}{
+		{name: "no command", args: nil, expectedErr: errUsage},
+		{name: "unknown command", args: []string{"nope"}, expectedErr: errUsage},
+		{name: "missing storages", args: []string{"migrate", "-from", "file:a.json"}, expectedErr: errUsage},
+		{name: "invalid limiter", args: []string{"serve", "-limiter", "api=100"}, expectedErr: errUsage},
+		{
+			name:        "unknown scheme",
+			args:        []string{"migrate", "-from", "nope://", "-to", "file:a.json"},
+			expectedErr: errUnknownScheme,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			err := run(context.Background(), test.args, &stdout, &stderr)
+
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("unexpected error, want %v, have %v", test.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+func runMigrate(ctx context.Context, args []string, stdout, stderr io.Writer) (err error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		from   = fs.String("from", "", "URL of the storage to copy windows from")
+		to     = fs.String("to", "", "URL of the storage to copy windows to")
+		dryRun = fs.Bool("dry-run", false, "report the windows to copy without writing them")
+	)
+
+	if err = fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *from == "" || *to == "" {
+		fmt.Fprintln(stderr, "migrate: -from and -to are required")
+		fs.Usage()
+		return errUsage
+	}
+
+	src, err := openStorage(ctx, *from, false)
+	if err != nil {
+		return fmt.Errorf("cannot open source: %w", err)
+	}
+	defer closeStorage(src, &err)
+
+	dst, err := openStorage(ctx, *to, true)
+	if err != nil {
+		return fmt.Errorf("cannot open destination: %w", err)
+	}
+	defer closeStorage(dst, &err)
+
+	report, err := pacemaker.Migrate(ctx, src.storage, dst.storage, pacemaker.MigrateOpts{DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+
+	return printReport(stdout, report)
+}
+
+func printReport(w io.Writer, report pacemaker.MigrationReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "WINDOW\tCOUNTER\tTTL\tEXISTING")
+	for _, m := range report.Windows {
+		fmt.Fprintf(
+			tw, "%s\t%d\t%s\t%d\n",
+			m.Window.UTC().Format(time.RFC3339Nano), m.Counter, m.TTL.Round(time.Millisecond), m.Existing,
+		)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if report.DryRun {
+		_, err := fmt.Fprintf(w, "dry run: %d windows would be migrated\n", len(report.Windows))
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%d windows migrated\n", len(report.Windows))
+	return err
+}
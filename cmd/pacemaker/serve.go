@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+// limiterFlags collects the repeated -limiter flags
+type limiterFlags []limiterSpec
+
+// limiterSpec defines a limiter served by name, as name=capacity/period, e.g. api=100/1m
+type limiterSpec struct {
+	name     string
+	capacity int64
+	period   time.Duration
+}
+
+var errInvalidLimiter = errors.New("invalid limiter, want name=capacity/period")
+
+func (f *limiterFlags) String() string {
+	specs := make([]string, len(*f))
+	for i, spec := range *f {
+		specs[i] = fmt.Sprintf("%s=%d/%s", spec.name, spec.capacity, spec.period)
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *limiterFlags) Set(value string) error {
+	spec, err := parseLimiterSpec(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+func parseLimiterSpec(value string) (limiterSpec, error) {
+	name, limit, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return limiterSpec{}, errInvalidLimiter
+	}
+
+	capacity, period, ok := strings.Cut(limit, "/")
+	if !ok {
+		return limiterSpec{}, errInvalidLimiter
+	}
+
+	c, err := strconv.ParseInt(capacity, 10, 64)
+	if err != nil || c <= 0 {
+		return limiterSpec{}, errInvalidLimiter
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return limiterSpec{}, errInvalidLimiter
+	}
+
+	return limiterSpec{name: name, capacity: c, period: d}, nil
+}
+
+func runServe(ctx context.Context, args []string, stdout, stderr io.Writer) (err error) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		limiters limiterFlags
+
+		addr      = fs.String("addr", ":8080", "address to listen at")
+		respAddr  = fs.String("resp-addr", "", "address to answer redis-cell CL.THROTTLE commands at, e.g. :6380")
+		rlsConfig = fs.String("rls-config", "", "JSON file of the Envoy rate limit service domains to serve at /json")
+		snapshot  = fs.String("snapshot", "", "file to restore counters from on start and to save them to on exit")
+		maxKeys   = fs.Int("max-keys", 0, "bound on the keys held in memory, zero means unbounded")
+	)
+
+	fs.Var(&limiters, "limiter", "limiter served by name, as name=capacity/period, e.g. api=100/1m. Repeatable.")
+
+	if err = fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{MaxKeys: *maxKeys})
+
+	if *snapshot != "" {
+		if err := restoreSnapshot(ctx, storage, *snapshot); err != nil {
+			return fmt.Errorf("cannot restore snapshot: %w", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", newTokenServer(storage, limiters, *maxKeys))
+
+	if *rlsConfig != "" {
+		rls, err := newRLSServer(storage, *rlsConfig, *maxKeys)
+		if err != nil {
+			return fmt.Errorf("cannot load rls config: %w", err)
+		}
+		mux.Handle("/json", rls)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: mux}
+
+	var respSrv *pacemaker.RESPServer
+
+	if *respAddr != "" {
+		respLn, err := net.Listen("tcp", *respAddr)
+		if err != nil {
+			_ = ln.Close()
+			return err
+		}
+
+		respSrv = pacemaker.NewRESPServer(pacemaker.RESPServerArgs{Storage: storage, MaxKeys: *maxKeys})
+
+		go func() {
+			if err := respSrv.Serve(respLn); err != nil {
+				fmt.Fprintf(stderr, "serve: resp: %v\n", err)
+			}
+		}()
+
+		fmt.Fprintf(stdout, "answering CL.THROTTLE on %s\n", respLn.Addr())
+	}
+
+	go storage.RunJanitor(ctx, time.Minute)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(stdout, "listening on %s\n", ln.Addr())
+
+	err = srv.Serve(ln)
+
+	if respSrv != nil {
+		_ = respSrv.Close()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if *snapshot != "" {
+		return saveSnapshot(storage, *snapshot)
+	}
+
+	return nil
+}
+
+// newTokenServer serves the counters of storage, along the limiters defined by specs. Limiters keep their
+// counters in storage as well, under the "limiter:<name>:" prefix.
+func newTokenServer(storage *pacemaker.KeyedMemoryStorage, specs []limiterSpec, maxKeys int) *pacemaker.TokenServer {
+	limiters := make(map[string]*pacemaker.KeyedRateLimiter, len(specs))
+
+	for _, spec := range specs {
+		spec := spec
+
+		limiters[spec.name] = pacemaker.NewKeyedRateLimiter(pacemaker.KeyedRateLimiterArgs{
+			New: func(key string) pacemaker.TokenFixedWindowRateLimiter {
+				return pacemaker.NewTokenFixedWindowRateLimiter(
+					pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+						Capacity: spec.capacity,
+						Rate:     pacemaker.Rate{Amount: 1, Unit: spec.period},
+						Clock:    pacemaker.NewClock(),
+						DB:       storage.For("limiter:" + spec.name + ":" + key),
+					}),
+				)
+			},
+			MaxKeys: maxKeys,
+		})
+	}
+
+	return pacemaker.NewTokenServer(pacemaker.TokenServerArgs{
+		Storage:  storage,
+		Limiters: limiters,
+	})
+}
+
+// newRLSServer serves the Envoy rate limit service domains of the JSON file at path, either a config or a list
+// of them
+func newRLSServer(storage *pacemaker.KeyedMemoryStorage, path string, maxKeys int) (*pacemaker.RLSServer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []pacemaker.RLSConfig
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		configs = make([]pacemaker.RLSConfig, 1)
+		err = json.Unmarshal(data, &configs[0])
+	} else {
+		err = json.Unmarshal(data, &configs)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pacemaker.NewRLSServer(pacemaker.RLSServerArgs{
+		Configs: configs,
+		Storage: storage,
+		MaxKeys: maxKeys,
+	})
+}
+
+func restoreSnapshot(ctx context.Context, storage *pacemaker.KeyedMemoryStorage, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return storage.Restore(ctx, data)
+}
+
+func saveSnapshot(storage *pacemaker.KeyedMemoryStorage, path string) error {
+	data, err := storage.Snapshot(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
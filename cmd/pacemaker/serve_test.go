@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+func TestServe_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	window := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+
+	// serve runs the server until its context is done, returning the client of the remote storage
+	serve := func(t *testing.T, ctx context.Context) (*pacemaker.RemoteClient, <-chan error) {
+		t.Helper()
+
+		stdout, w := io.Pipe()
+		done := make(chan error, 1)
+
+		go func() {
+			done <- run(ctx, []string{"serve", "-addr", "127.0.0.1:0", "-snapshot", path}, w, io.Discard)
+			_ = w.Close()
+		}()
+
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error, want none, have %v: %v", err, <-done)
+		}
+		go func() { _, _ = io.Copy(io.Discard, stdout) }()
+
+		cli := pacemaker.NewRemoteClient(pacemaker.RemoteClientOpts{
+			URL: "http://" + strings.TrimSpace(strings.TrimPrefix(line, "listening on ")),
+		})
+		t.Cleanup(cli.Close)
+
+		return cli, done
+	}
+
+	args := pacemaker.FixedWindowIncArgs{Window: window, TTL: time.Minute, Tokens: 3, Capacity: 10}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cli, done := serve(t, ctx)
+
+	if _, err := cli.Storage("bot").Inc(ctx, args); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected snapshot file, have error %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	cli, _ = serve(t, ctx)
+
+	if c, _ := cli.Storage("bot").Inc(ctx, args); c != 6 {
+		t.Errorf("unexpected counter after restore, want 6, have %d", c)
+	}
+}
+
+func TestParseLimiterSpec(t *testing.T) {
+	tests := []struct {
+		value        string
+		expectedSpec limiterSpec
+		expectedErr  error
+	}{
+		{value: "api=100/1m", expectedSpec: limiterSpec{name: "api", capacity: 100, period: time.Minute}},
+		{value: "api=100", expectedErr: errInvalidLimiter},
+		{value: "=100/1m", expectedErr: errInvalidLimiter},
+		{value: "api=0/1m", expectedErr: errInvalidLimiter},
+		{value: "api=100/forever", expectedErr: errInvalidLimiter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			spec, err := parseLimiterSpec(test.value)
+
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("unexpected error, want %v, have %v", test.expectedErr, err)
+			}
+
+			if spec != test.expectedSpec {
+				t.Errorf("unexpected spec, want %+v, have %+v", test.expectedSpec, spec)
+			}
+		})
+	}
+}
+
+func TestNewRLSServer_Config(t *testing.T) {
+	config := `{"domain": "edge", "descriptors": [{"key": "user", "rate_limit": {"unit": "second", "requests_per_unit": 1}}]}`
+
+	tests := []struct {
+		name        string
+		data        string
+		expectedErr bool
+	}{
+		{name: "single domain", data: config, expectedErr: false},
+		{name: "list of domains", data: "[" + config + "]", expectedErr: false},
+		{name: "duplicated domain", data: "[" + config + "," + config + "]", expectedErr: true},
+		{name: "malformed", data: "{", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rls.json")
+			if err := os.WriteFile(path, []byte(test.data), 0o600); err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{})
+
+			if _, err := newRLSServer(storage, path, 0); (err != nil) != test.expectedErr {
+				t.Errorf("unexpected error, want error=%v, have %v", test.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v8"
+	_ "github.com/lib/pq"
+	"github.com/sonirico/pacemaker"
+	bolt "go.etcd.io/bbolt"
+	_ "modernc.org/sqlite"
+)
+
+type (
+	// storage is the set of methods pacemaker storages share. Storages are handed to pacemaker as they are, so
+	// that the optional methods they implement, such as Snapshot, are kept.
+	storage interface {
+		Inc(ctx context.Context, args pacemaker.FixedWindowIncArgs) (int64, error)
+		Get(ctx context.Context, window time.Time) (int64, error)
+	}
+
+	// openedStorage is a storage opened from a URL, along how to release it
+	openedStorage struct {
+		storage storage
+		close   func() error
+	}
+
+	// fileStorage is a memory storage persisted as a snapshot file
+	fileStorage struct {
+		*pacemaker.FixedWindowMemoryStorage
+		path  string
+		dirty bool
+	}
+)
+
+var errUnknownScheme = errors.New("unknown storage scheme")
+
+func (s openedStorage) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// closeStorage closes s, reporting its error unless another one happened before
+func closeStorage(s openedStorage, err *error) {
+	if cerr := s.Close(); cerr != nil && *err == nil {
+		*err = cerr
+	}
+}
+
+// openStorage opens the storage addressed by rawURL. Schemas are only created for destinations.
+func openStorage(ctx context.Context, rawURL string, destination bool) (openedStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return openedStorage{}, err
+	}
+
+	q := u.Query()
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		prefix := q.Get("prefix")
+		q.Del("prefix")
+		u.RawQuery = q.Encode()
+
+		opts, err := redis.ParseURL(u.String())
+		if err != nil {
+			return openedStorage{}, err
+		}
+
+		cli := redis.NewClient(opts)
+
+		return openedStorage{
+			storage: pacemaker.NewFixedWindowRedisStorage(cli, pacemaker.FixedWindowRedisStorageOpts{Prefix: prefix}),
+			close:   cli.Close,
+		}, nil
+	case "memcached":
+		exact, _ := strconv.ParseBool(q.Get("exact"))
+
+		cli := memcache.New(strings.Split(u.Host, ",")...)
+
+		return openedStorage{
+			storage: pacemaker.NewFixedWindowMemcachedStorage(cli, pacemaker.FixedWindowMemcachedStorageOpts{
+				Prefix: q.Get("prefix"),
+				Exact:  exact,
+			}),
+		}, nil
+	case "bolt":
+		db, err := bolt.Open(filePath(u), 0o600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return openedStorage{}, err
+		}
+
+		s, err := pacemaker.NewFixedWindowBoltStorage(db, pacemaker.FixedWindowBoltStorageOpts{Key: q.Get("key")})
+		if err != nil {
+			_ = db.Close()
+			return openedStorage{}, err
+		}
+
+		return openedStorage{storage: s, close: db.Close}, nil
+	case "sqlite":
+		db, err := sql.Open("sqlite", filePath(u))
+		if err != nil {
+			return openedStorage{}, err
+		}
+
+		db.SetMaxOpenConns(1)
+
+		return openSQLStorage(ctx, db, q, pacemaker.SQLDialectSQLite, destination)
+	case "postgres", "postgresql":
+		opts := url.Values{}
+		for _, name := range []string{"key", "table"} {
+			opts.Set(name, q.Get(name))
+			q.Del(name)
+		}
+		u.RawQuery = q.Encode()
+
+		db, err := sql.Open("postgres", u.String())
+		if err != nil {
+			return openedStorage{}, err
+		}
+
+		return openSQLStorage(ctx, db, opts, pacemaker.SQLDialectPostgres, destination)
+	case "file":
+		return openFileStorage(ctx, filePath(u))
+	default:
+		return openedStorage{}, fmt.Errorf("%w: %q", errUnknownScheme, u.Scheme)
+	}
+}
+
+func openSQLStorage(
+	ctx context.Context,
+	db *sql.DB,
+	q url.Values,
+	dialect pacemaker.SQLDialect,
+	destination bool,
+) (openedStorage, error) {
+	s, err := pacemaker.NewFixedWindowSQLStorage(db, pacemaker.FixedWindowSQLStorageOpts{
+		Table:   q.Get("table"),
+		Key:     q.Get("key"),
+		Dialect: dialect,
+	})
+	if err != nil {
+		_ = db.Close()
+		return openedStorage{}, err
+	}
+
+	if destination {
+		if err := s.CreateTable(ctx); err != nil {
+			_ = db.Close()
+			return openedStorage{}, err
+		}
+	}
+
+	return openedStorage{storage: s, close: db.Close}, nil
+}
+
+func openFileStorage(ctx context.Context, path string) (openedStorage, error) {
+	s := &fileStorage{
+		FixedWindowMemoryStorage: pacemaker.NewFixedWindowMemoryStorage(),
+		path:                     path,
+	}
+
+	data, err := os.ReadFile(path)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return openedStorage{}, err
+	default:
+		if err := s.Restore(ctx, data); err != nil {
+			return openedStorage{}, err
+		}
+	}
+
+	return openedStorage{storage: s, close: s.Close}, nil
+}
+
+func (s *fileStorage) Inc(ctx context.Context, args pacemaker.FixedWindowIncArgs) (int64, error) {
+	s.dirty = true
+	return s.FixedWindowMemoryStorage.Inc(ctx, args)
+}
+
+// Close writes the snapshot file back, if it was modified
+func (s *fileStorage) Close() error {
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := s.Snapshot(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path, data, 0o600)
+}
+
+// filePath returns the path of file URLs, either absolute, as in scheme:///path, or relative, as in scheme:path
+func filePath(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Host + u.Path
+}
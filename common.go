@@ -5,3 +5,9 @@ import "time"
 type clock interface {
 	Now() time.Time
 }
+
+// timerClock is a clock able to wait, such as RealClock and TestClock
+type timerClock interface {
+	clock
+	NewTimer(d time.Duration) Timer
+}
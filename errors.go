@@ -3,8 +3,17 @@ package pacemaker
 import "errors"
 
 var (
-	ErrRateLimitExceeded         = errors.New("rate limit exceeded")
-	ErrTokensGreaterThanCapacity = errors.New("tokens are greater than capacity")
-	ErrCannotLoadScript          = errors.New("cannot load LUA script")
-	ErrNoLastKey                 = errors.New("there is not last key")
+	ErrRateLimitExceeded          = errors.New("rate limit exceeded")
+	ErrTokensGreaterThanCapacity  = errors.New("tokens are greater than capacity")
+	ErrCannotLoadScript           = errors.New("cannot load LUA script")
+	ErrNoLastKey                  = errors.New("there is not last key")
+	ErrEmptyKey                   = errors.New("storage key cannot be empty")
+	ErrInvalidTableName           = errors.New("invalid sql table name")
+	ErrTooManyConflicts           = errors.New("too many conflicts updating the storage")
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+	ErrMigrationUnsupported       = errors.New("storage cannot be migrated from")
+	ErrRemoteClientClosed         = errors.New("remote client closed")
+	ErrUnknownDomain              = errors.New("unknown rate limit domain")
+	ErrEmptyPool                  = errors.New("pool has no members")
+	ErrInvalidDecay               = errors.New("decay must be positive")
 )
@@ -0,0 +1,178 @@
+// Package etcd provides pacemaker storages backed by etcd. It lives in its own module so that pacemaker users not
+// relying on etcd do not pull its dependencies.
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type (
+	FixedWindowStorageOpts struct {
+		Prefix string
+		// MaxRetries bounds the retries of transactions losing the compare-and-swap under contention.
+		// Defaults to 16.
+		MaxRetries int
+	}
+
+	// FixedWindowStorage keeps the rate limit state in etcd. Every window is a key increased by a transaction
+	// comparing its revision, retried on conflicts. Windows are attached to a lease granted with the TTL of
+	// the request creating them, so etcd removes them once the window is over. Note etcd enforces a minimum lease
+	// TTL, about two seconds with the default heartbeat and election timeouts, so shorter windows outlive their TTL.
+	FixedWindowStorage struct {
+		cli *clientv3.Client
+
+		opts FixedWindowStorageOpts
+
+		keyGenerator func(time.Time) string
+	}
+)
+
+const (
+	keySep            = "|"
+	defaultMaxRetries = 16
+)
+
+// Inc will increase, if there is room to, the rate limiting counter for the bucket
+// specified by window argument.
+func (s FixedWindowStorage) Inc(
+	ctx context.Context,
+	args pacemaker.FixedWindowIncArgs,
+) (int64, error) {
+	key := s.keyGenerator(args.Window)
+
+	for i := 0; i <= s.opts.MaxRetries; i++ {
+		resp, err := s.cli.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		var (
+			counter int64
+			cmp     clientv3.Cmp
+			put     clientv3.Op
+			lease   clientv3.LeaseID
+		)
+
+		if len(resp.Kvs) == 0 {
+			cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		} else {
+			kv := resp.Kvs[0]
+			counter, err = strconv.ParseInt(string(kv.Value), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+		}
+
+		counter += args.Tokens
+
+		if counter > args.Capacity {
+			return counter, nil
+		}
+
+		value := strconv.FormatInt(counter, 10)
+
+		if len(resp.Kvs) == 0 {
+			grant, err := s.cli.Grant(ctx, leaseTTL(args.TTL))
+			if err != nil {
+				return 0, err
+			}
+			lease = grant.ID
+			put = clientv3.OpPut(key, value, clientv3.WithLease(lease))
+		} else {
+			put = clientv3.OpPut(key, value, clientv3.WithIgnoreLease())
+		}
+
+		txn, err := s.cli.Txn(ctx).If(cmp).Then(put).Commit()
+		if err != nil {
+			return 0, err
+		}
+
+		if txn.Succeeded {
+			return counter, nil
+		}
+
+		if lease != clientv3.NoLease {
+			// Somebody else created the window in the meantime
+			if _, err := s.cli.Revoke(ctx, lease); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return 0, pacemaker.ErrTooManyConflicts
+}
+
+func (s FixedWindowStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (counter int64, err error) {
+	var resp *clientv3.GetResponse
+	resp, err = s.cli.Get(ctx, s.keyGenerator(window))
+	if err != nil {
+		return
+	}
+
+	if len(resp.Kvs) == 0 {
+		return
+	}
+
+	counter, err = strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+	return
+}
+
+func (s FixedWindowStorage) LastWindow(ctx context.Context) (ts time.Time, err error) {
+	var resp *clientv3.GetResponse
+	resp, err = s.cli.Get(
+		ctx,
+		s.opts.Prefix+keySep,
+		clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend),
+		clientv3.WithLimit(1),
+		clientv3.WithKeysOnly(),
+	)
+	if err != nil {
+		return
+	}
+
+	if len(resp.Kvs) == 0 {
+		err = pacemaker.ErrNoLastKey
+		return
+	}
+
+	ts, err = pacemaker.LatestTsFromKeys([]string{string(resp.Kvs[0].Key)}, keySep)
+	return
+}
+
+// leaseTTL converts ttl to lease seconds, rounded up
+func leaseTTL(ttl time.Duration) int64 {
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+func NewFixedWindowStorage(
+	cli *clientv3.Client,
+	opts FixedWindowStorageOpts,
+) FixedWindowStorage {
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = defaultMaxRetries
+	}
+
+	return FixedWindowStorage{
+		cli:  cli,
+		opts: opts,
+		keyGenerator: func(t time.Time) string {
+			// Zero padded so that keys sort lexicographically by time
+			return fmt.Sprintf("%s%s%020d", opts.Prefix, keySep, t.UnixNano())
+		},
+	}
+}
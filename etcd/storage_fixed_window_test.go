@@ -0,0 +1,133 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+var (
+	cli *clientv3.Client
+
+	prefixes int64
+)
+
+func freeURL() url.URL {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("cannot find a free port: %v", err)
+	}
+	defer ln.Close()
+
+	return url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "pacemaker-etcd")
+	if err != nil {
+		log.Fatalf("cannot create etcd dir: %v", err)
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	client, peer := freeURL(), freeURL()
+	cfg.LCUrls, cfg.ACUrls = []url.URL{client}, []url.URL{client}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	srv, err := embed.StartEtcd(cfg)
+	if err != nil {
+		log.Fatalf("cannot start etcd: %v", err)
+	}
+
+	select {
+	case <-srv.Server.ReadyNotify():
+	case <-time.After(time.Minute):
+		log.Fatalf("etcd took too long to start")
+	}
+
+	cli, err = clientv3.New(clientv3.Config{
+		Endpoints:   []string{client.String()},
+		DialTimeout: time.Second * 5,
+	})
+	if err != nil {
+		log.Fatalf("cannot connect to etcd: %v", err)
+	}
+
+	code := m.Run()
+
+	_ = cli.Close()
+	srv.Close()
+	_ = os.RemoveAll(dir)
+
+	os.Exit(code)
+}
+
+func newStorage(name string) FixedWindowStorage {
+	return NewFixedWindowStorage(cli, FixedWindowStorageOpts{
+		Prefix:     fmt.Sprintf("pacemaker|%s|%d", name, atomic.AddInt64(&prefixes, 1)),
+		MaxRetries: 1000,
+	})
+}
+
+func TestFixedWindowStorage_Conformance(t *testing.T) {
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			return newStorage("conformance")
+		},
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+func TestFixedWindowStorage_WindowsAreLeasedWithTTL(t *testing.T) {
+	ctx := context.Background()
+	storage := newStorage("lease")
+	window := time.Date(2022, 02, 05, 10, 23, 30, 0, time.UTC)
+
+	for _, ttl := range []time.Duration{time.Millisecond * 9500, time.Second * 3} {
+		_, err := storage.Inc(ctx, pacemaker.FixedWindowIncArgs{
+			Window:   window,
+			TTL:      ttl,
+			Tokens:   1,
+			Capacity: 10,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on inc, want none, have %v", err)
+		}
+	}
+
+	resp, err := cli.Get(ctx, storage.keyGenerator(window))
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("unexpected window, want one key, have %v and error %v", resp.Kvs, err)
+	}
+
+	lease, err := cli.TimeToLive(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+	if err != nil {
+		t.Fatalf("unexpected error on lease, want none, have %v", err)
+	}
+
+	// Increments within an existing window keep the lease granted on creation
+	if lease.GrantedTTL != 10 {
+		t.Errorf("unexpected lease ttl, want 10, have %d", lease.GrantedTTL)
+	}
+
+	if _, err := cli.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		t.Fatalf("unexpected error on revoke, want none, have %v", err)
+	}
+
+	if c, _ := storage.Get(ctx, window); c != 0 {
+		t.Errorf("unexpected counter after lease expiry, want 0, have %d", c)
+	}
+}
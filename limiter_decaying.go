@@ -0,0 +1,224 @@
+package pacemaker
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	decayingStorage interface {
+		Inc(ctx context.Context, args DecayingIncArgs) (float64, error)
+		Get(ctx context.Context, now time.Time, decay float64) (float64, error)
+	}
+
+	DecayingIncArgs struct {
+		// Now is the time the counter is decayed until
+		Now time.Time
+		// Decay is how much the counter decreases per second
+		Decay    float64
+		Tokens   int64
+		Capacity int64
+	}
+
+	DecayingArgs struct {
+		// Capacity is the maximum the counter can reach, e.g. 20 for Kraken intermediate accounts
+		Capacity int64
+		// Decay is how much the counter decreases per second, e.g. 0.5 for Kraken intermediate accounts. Must be
+		// positive.
+		Decay float64
+
+		Clock clock
+
+		DB decayingStorage
+	}
+)
+
+// DecayingRateLimiter limits requests by a counter increased by the cost of every request and decreasing
+// continuously over time, as Kraken does. E.g:
+// Capacity: 15, decay: 0.33 per second
+// Requests may be made until the counter reaches 15, then one every ~3 seconds, or a burst of 15 once idle for
+// ~45 seconds.
+type DecayingRateLimiter struct {
+	db    decayingStorage
+	clock clock
+
+	mu sync.Mutex
+
+	capacity int64
+	decay    float64
+	penalty  penaltyBox
+
+	validateTokens func(int64) int64
+}
+
+// Try increases the counter by tokens, unless it would exceed the capacity, in which case it returns how long to
+// wait for the counter to decay enough and ErrRateLimitExceeded
+func (l *DecayingRateLimiter) Try(ctx context.Context, tokens int64) (Result, error) {
+	tokens = l.validateTokens(tokens)
+	if tokens > l.capacity {
+		return nores, ErrTokensGreaterThanCapacity
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil {
+		return nores, err
+	} else if wait > 0 {
+		return res(wait, 0), ErrRateLimitExceeded
+	}
+
+	c, err := l.db.Inc(ctx, DecayingIncArgs{Now: now, Decay: l.decay, Tokens: tokens, Capacity: l.capacity})
+	if err != nil {
+		return nores, err
+	}
+
+	if c > float64(l.capacity) {
+		return res(l.waitFor(c), l.freeSlots(c-float64(tokens))), ErrRateLimitExceeded
+	}
+
+	return res(0, l.freeSlots(c)), nil
+}
+
+// Check returns whether tokens fit in the counter without increasing it, and how long to wait otherwise
+func (l *DecayingRateLimiter) Check(ctx context.Context, tokens int64) (Result, error) {
+	tokens = l.validateTokens(tokens)
+	if tokens > l.capacity {
+		return nores, ErrTokensGreaterThanCapacity
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil {
+		return nores, err
+	} else if wait > 0 {
+		return res(wait, 0), ErrRateLimitExceeded
+	}
+
+	c, err := l.db.Get(ctx, now, l.decay)
+	if err != nil {
+		return nores, err
+	}
+
+	if c+float64(tokens) > float64(l.capacity) {
+		return res(l.waitFor(c+float64(tokens)), l.freeSlots(c)), ErrRateLimitExceeded
+	}
+
+	return res(0, l.freeSlots(c)), nil
+}
+
+// Dump returns the free slots of the counter and, once it is full, how long to wait for the next one
+func (l *DecayingRateLimiter) Dump(ctx context.Context) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	if ttw, err := l.penalty.wait(ctx, l.db, now); err != nil || ttw > 0 {
+		return res(ttw, 0), err
+	}
+
+	c, err := l.db.Get(ctx, now, l.decay)
+	if err != nil {
+		return nores, err
+	}
+
+	free := l.freeSlots(c)
+	if free > 0 {
+		return res(0, free), nil
+	}
+
+	return res(l.waitFor(c+1), free), nil
+}
+
+// Penalize blocks the rate limiter until the given time, e.g. once the server locks the client out
+func (l *DecayingRateLimiter) Penalize(ctx context.Context, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.penalty.penalize(ctx, l.db, until, l.clock.Now())
+}
+
+// waitFor returns how long it takes for the counter c to decay down to the capacity
+func (l *DecayingRateLimiter) waitFor(c float64) time.Duration {
+	excess := c - float64(l.capacity)
+	if excess <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(excess / l.decay * float64(time.Second)))
+}
+
+func (l *DecayingRateLimiter) freeSlots(c float64) int64 {
+	return int64(math.Floor(float64(l.capacity) - c))
+}
+
+// NewDecayingRateLimiter returns a new instance of DecayingRateLimiter from struct of args, or ErrInvalidDecay
+// unless the decay is positive, as the counter would never go down otherwise
+func NewDecayingRateLimiter(args DecayingArgs) (*DecayingRateLimiter, error) {
+	if !(args.Decay > 0) {
+		return nil, ErrInvalidDecay
+	}
+
+	return &DecayingRateLimiter{
+		capacity:       args.Capacity,
+		decay:          args.Decay,
+		clock:          args.Clock,
+		db:             args.DB,
+		validateTokens: AtLeast(1),
+	}, nil
+}
+
+// decayCounter returns the counter c updated at a, decayed as of now
+func decayCounter(c float64, at, now time.Time, decay float64) float64 {
+	if elapsed := now.Sub(at); elapsed > 0 {
+		c -= elapsed.Seconds() * decay
+	}
+
+	return math.Max(c, 0)
+}
+
+// DecayingMemoryStorage is an in-memory storage for the counter of a DecayingRateLimiter. Preferred option when
+// testing and working with standalone instances of your program and do not care about it restarting and not
+// being exactly compliant with the state of rate limits at the server
+type DecayingMemoryStorage struct {
+	mu sync.Mutex
+
+	counter float64
+	at      time.Time
+}
+
+// Inc decays the counter as of args.Now and increases it by args.Tokens, if there is room to. It returns the
+// counter the tokens would bring, even if exceeding the capacity.
+func (s *DecayingMemoryStorage) Inc(ctx context.Context, args DecayingIncArgs) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := decayCounter(s.counter, s.at, args.Now, args.Decay) + float64(args.Tokens)
+
+	if c <= float64(args.Capacity) {
+		s.counter = c
+		s.at = args.Now
+	}
+
+	return c, ctx.Err()
+}
+
+// Get returns the counter decayed as of now
+func (s *DecayingMemoryStorage) Get(ctx context.Context, now time.Time, decay float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return decayCounter(s.counter, s.at, now, decay), ctx.Err()
+}
+
+func NewDecayingMemoryStorage() *DecayingMemoryStorage {
+	return &DecayingMemoryStorage{}
+}
@@ -0,0 +1,194 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+type decayingStorage interface {
+	Inc(ctx context.Context, args pacemaker.DecayingIncArgs) (float64, error)
+	Get(ctx context.Context, now time.Time, decay float64) (float64, error)
+}
+
+func TestDecayingRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	storages := []struct {
+		name string
+		new  func(t *testing.T) decayingStorage
+	}{
+		{
+			name: "memory",
+			new: func(*testing.T) decayingStorage {
+				return pacemaker.NewDecayingMemoryStorage()
+			},
+		},
+		{
+			name: "redis",
+			new: func(t *testing.T) decayingStorage {
+				cli := pacemakertest.NewRedis(t).Client
+				return pacemaker.NewDecayingRedisStorage(cli, pacemaker.DecayingRedisStorageOpts{Prefix: "kraken"})
+			},
+		},
+	}
+
+	steps := []struct {
+		method      string
+		tokens      int64
+		forward     time.Duration
+		expectedRes pacemaker.Result
+		expectedErr error
+	}{
+		{method: "try", tokens: 2, expectedRes: pacemaker.Result{FreeSlots: 1}},
+		{
+			method:      "try",
+			tokens:      2,
+			expectedRes: pacemaker.Result{TimeToWait: 2 * time.Second, FreeSlots: 1},
+			expectedErr: pacemaker.ErrRateLimitExceeded,
+		},
+		{method: "check", tokens: 1, expectedRes: pacemaker.Result{FreeSlots: 1}},
+		{
+			method:      "try",
+			tokens:      2,
+			forward:     time.Second,
+			expectedRes: pacemaker.Result{TimeToWait: time.Second, FreeSlots: 1},
+			expectedErr: pacemaker.ErrRateLimitExceeded,
+		},
+		{method: "try", tokens: 2, forward: time.Second, expectedRes: pacemaker.Result{FreeSlots: 0}},
+		{method: "dump", expectedRes: pacemaker.Result{TimeToWait: 2 * time.Second, FreeSlots: 0}},
+		{
+			method:      "check",
+			tokens:      1,
+			forward:     500 * time.Millisecond,
+			expectedRes: pacemaker.Result{TimeToWait: 1500 * time.Millisecond, FreeSlots: 0},
+			expectedErr: pacemaker.ErrRateLimitExceeded,
+		},
+		{method: "dump", forward: 6 * time.Second, expectedRes: pacemaker.Result{FreeSlots: 3}},
+		{method: "try", tokens: 4, expectedErr: pacemaker.ErrTokensGreaterThanCapacity},
+		{method: "check", tokens: 4, expectedErr: pacemaker.ErrTokensGreaterThanCapacity},
+		{method: "try", tokens: -5, expectedRes: pacemaker.Result{FreeSlots: 2}},
+		{method: "try", tokens: 0, expectedRes: pacemaker.Result{FreeSlots: 1}},
+		{method: "check", tokens: -1, expectedRes: pacemaker.Result{FreeSlots: 1}},
+		{
+			method:      "check",
+			tokens:      2,
+			expectedRes: pacemaker.Result{TimeToWait: 2 * time.Second, FreeSlots: 1},
+			expectedErr: pacemaker.ErrRateLimitExceeded,
+		},
+	}
+
+	for _, storage := range storages {
+		t.Run(storage.name, func(t *testing.T) {
+			clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+			limiter, err := pacemaker.NewDecayingRateLimiter(pacemaker.DecayingArgs{
+				Capacity: 3,
+				Decay:    0.5,
+				Clock:    clock,
+				DB:       storage.new(t),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			for i, step := range steps {
+				clock.Forward(step.forward)
+
+				var (
+					r   pacemaker.Result
+					err error
+				)
+
+				switch step.method {
+				case "try":
+					r, err = limiter.Try(ctx, step.tokens)
+				case "check":
+					r, err = limiter.Check(ctx, step.tokens)
+				case "dump":
+					r, err = limiter.Dump(ctx)
+				}
+
+				if !errors.Is(err, step.expectedErr) {
+					t.Errorf("step(%s, %d) unexpected error, want %v, have %v", step.method, i, step.expectedErr, err)
+				}
+
+				if r != step.expectedRes {
+					t.Errorf("step(%s, %d) unexpected result, want %+v, have %+v", step.method, i, step.expectedRes, r)
+				}
+			}
+		})
+	}
+}
+
+func TestDecayingRedisStorage_Shared(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	redis := pacemakertest.NewRedis(t)
+
+	newLimiter := func() *pacemaker.DecayingRateLimiter {
+		l, err := pacemaker.NewDecayingRateLimiter(pacemaker.DecayingArgs{
+			Capacity: 2,
+			Decay:    0.25,
+			Clock:    clock,
+			DB:       pacemaker.NewDecayingRedisStorage(redis.Client, pacemaker.DecayingRedisStorageOpts{Prefix: "kraken"}),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+		return l
+	}
+
+	a, b := newLimiter(), newLimiter()
+
+	if _, err := a.Try(ctx, 2); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	r, err := b.Try(ctx, 1)
+	if !errors.Is(err, pacemaker.ErrRateLimitExceeded) || r.TimeToWait != 4*time.Second {
+		t.Errorf("unexpected result, want %v after %v, have %v after %v",
+			pacemaker.ErrRateLimitExceeded, 4*time.Second, err, r.TimeToWait)
+	}
+
+	// The counter expires once fully decayed
+	if ttl := redis.Client.PTTL(ctx, "kraken|decaying").Val(); ttl <= 0 || ttl > 8*time.Second+time.Millisecond {
+		t.Errorf("unexpected ttl, want up to 8s, have %v", ttl)
+	}
+}
+
+func TestNewDecayingRateLimiter_InvalidDecay(t *testing.T) {
+	for _, decay := range []float64{0, -0.5} {
+		_, err := pacemaker.NewDecayingRateLimiter(pacemaker.DecayingArgs{
+			Capacity: 3,
+			Decay:    decay,
+			Clock:    pacemaker.NewClock(),
+			DB:       pacemaker.NewDecayingMemoryStorage(),
+		})
+
+		if !errors.Is(err, pacemaker.ErrInvalidDecay) {
+			t.Errorf("unexpected error for decay %v, want %v, have %v", decay, pacemaker.ErrInvalidDecay, err)
+		}
+	}
+}
+
+func TestDecayingMemoryStorage_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := pacemaker.NewDecayingMemoryStorage()
+	now := time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC)
+
+	_, err := s.Inc(ctx, pacemaker.DecayingIncArgs{Now: now, Decay: 1, Tokens: 1, Capacity: 3})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error, want %v, have %v", context.Canceled, err)
+	}
+
+	if _, err := s.Get(ctx, now, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error, want %v, have %v", context.Canceled, err)
+	}
+}
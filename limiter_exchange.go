@@ -0,0 +1,267 @@
+package pacemaker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type (
+	// ExchangeLimitType is the kind of usage a rate limit of an exchange accounts for, e.g. REQUEST_WEIGHT
+	ExchangeLimitType string
+
+	// ExchangeRateLimit is an entry of the rateLimits array exchange info endpoints return, e.g.
+	//
+	//	{"rateLimitType": "REQUEST_WEIGHT", "interval": "MINUTE", "intervalNum": 1, "limit": 1200}
+	ExchangeRateLimit struct {
+		RateLimitType ExchangeLimitType `json:"rateLimitType"`
+		// Interval is one of SECOND, MINUTE, HOUR or DAY
+		Interval    string `json:"interval"`
+		IntervalNum int    `json:"intervalNum"`
+		Limit       int64  `json:"limit"`
+	}
+
+	ExchangeLimitsArgs struct {
+		RateLimits []ExchangeRateLimit
+		// NewStorage returns the storage of a rate limit, e.g. a FixedWindowRedisStorage prefixed by its Key.
+		// Defaults to a FixedTruncatedWindowMemoryStorage per rate limit.
+		NewStorage func(limit ExchangeRateLimit) fixedTruncatedWindowStorage
+		// Clock defaults to RealClock
+		Clock clock
+	}
+
+	// ExchangeLimit is a rate limit of an exchange along the rate limiter enforcing it
+	ExchangeLimit struct {
+		ExchangeRateLimit
+		Rate    Rate
+		Limiter *TokenFixedWindowRateLimiter
+	}
+
+	// ExchangeLimiter enforces every rate limit of a type at once, such as the orders per 10 seconds and per day
+	ExchangeLimiter struct {
+		limits []ExchangeLimit
+	}
+
+	// ExchangeLimits holds the rate limiters of an exchange, looked up by type
+	ExchangeLimits struct {
+		limiters map[ExchangeLimitType]*ExchangeLimiter
+	}
+)
+
+const (
+	ExchangeLimitRequestWeight ExchangeLimitType = "REQUEST_WEIGHT"
+	ExchangeLimitOrders        ExchangeLimitType = "ORDERS"
+	ExchangeLimitRawRequests   ExchangeLimitType = "RAW_REQUESTS"
+)
+
+var exchangeIntervals = map[string]struct {
+	unit   time.Duration
+	letter string
+}{
+	"SECOND": {unit: time.Second, letter: "S"},
+	"MINUTE": {unit: time.Minute, letter: "M"},
+	"HOUR":   {unit: time.Hour, letter: "H"},
+	"DAY":    {unit: 24 * time.Hour, letter: "D"},
+}
+
+// Key identifies the rate limit among the ones of an exchange, e.g. REQUEST_WEIGHT:1M
+func (l ExchangeRateLimit) Key() string {
+	return fmt.Sprintf("%s:%s", l.RateLimitType, l.interval())
+}
+
+// interval returns the interval as exchanges suffix their usage headers with, e.g. 1M
+func (l ExchangeRateLimit) interval() string {
+	return fmt.Sprintf("%d%s", l.IntervalNum, exchangeIntervals[l.Interval].letter)
+}
+
+// rate returns the rate of windows aligned to the interval, as exchanges count them. Rate.TruncateDuration
+// truncates sub-minute windows to their unit alone, so 10 seconds windows are modelled as a single 10 seconds unit.
+func (l ExchangeRateLimit) rate() (Rate, error) {
+	interval, ok := exchangeIntervals[l.Interval]
+	if !ok {
+		return Rate{}, fmt.Errorf("exchange: invalid interval %q of rate limit %s", l.Interval, l.RateLimitType)
+	}
+
+	if l.IntervalNum <= 0 {
+		return Rate{}, fmt.Errorf("exchange: interval number of rate limit %s must be positive", l.RateLimitType)
+	}
+
+	if l.Limit <= 0 {
+		return Rate{}, fmt.Errorf("exchange: limit of rate limit %s must be positive", l.RateLimitType)
+	}
+
+	if interval.unit < time.Minute {
+		return Rate{Amount: 1, Unit: time.Duration(l.IntervalNum) * interval.unit}, nil
+	}
+
+	return Rate{Amount: l.IntervalNum, Unit: interval.unit}, nil
+}
+
+// ParseExchangeRateLimits parses the rate limits of an exchange info response, either the whole response holding
+// a rateLimits array or the array alone
+func ParseExchangeRateLimits(data []byte) ([]ExchangeRateLimit, error) {
+	var limits []ExchangeRateLimit
+	if err := json.Unmarshal(data, &limits); err == nil {
+		return limits, nil
+	}
+
+	var info struct {
+		RateLimits []ExchangeRateLimit `json:"rateLimits"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+
+	return info.RateLimits, nil
+}
+
+// Limiter returns the rate limiter of a type, if the exchange has any rate limit of it
+func (e *ExchangeLimits) Limiter(t ExchangeLimitType) (*ExchangeLimiter, bool) {
+	l, ok := e.limiters[t]
+	return l, ok
+}
+
+// UsageHeaders returns the headers reporting the usage of the rate limits of every type in prefixes, as Binance
+// names them, to sync them through TransportOpts.Usage, e.g.
+//
+//	limits.UsageHeaders(map[pacemaker.ExchangeLimitType]string{
+//		pacemaker.ExchangeLimitRequestWeight: "X-MBX-USED-WEIGHT-",
+//		pacemaker.ExchangeLimitOrders:        "X-MBX-ORDER-COUNT-",
+//	})
+//
+// maps X-MBX-USED-WEIGHT-1M to the request weight per minute.
+func (e *ExchangeLimits) UsageHeaders(prefixes map[ExchangeLimitType]string) []UsageHeader {
+	var headers []UsageHeader
+
+	for t, prefix := range prefixes {
+		l, ok := e.limiters[t]
+		if !ok {
+			continue
+		}
+
+		for _, limit := range l.limits {
+			headers = append(headers, UsageHeader{Header: prefix + limit.interval(), Limiter: limit.Limiter})
+		}
+	}
+
+	return headers
+}
+
+// Limits returns the rate limits enforced, in the order of the exchange info
+func (l *ExchangeLimiter) Limits() []ExchangeLimit {
+	return l.limits
+}
+
+// Try consumes tokens from every rate limit, once all of them have room for them. Otherwise, it returns the
+// longest time to wait. Tokens consumed by the rate limits tried before one that is exceeded meanwhile are not
+// given back.
+func (l *ExchangeLimiter) Try(ctx context.Context, tokens int64) (Result, error) {
+	if r, err := l.Check(ctx, tokens); err != nil {
+		return r, err
+	}
+
+	return l.each(func(limit ExchangeLimit) (Result, error) {
+		return limit.Limiter.Try(ctx, tokens)
+	})
+}
+
+// Check returns whether tokens fit in every rate limit, with the fewest free slots among them
+func (l *ExchangeLimiter) Check(ctx context.Context, tokens int64) (Result, error) {
+	return l.each(func(limit ExchangeLimit) (Result, error) {
+		return limit.Limiter.Check(ctx, tokens)
+	})
+}
+
+// Dump returns the state of the most restrictive rate limit
+func (l *ExchangeLimiter) Dump(ctx context.Context) (Result, error) {
+	return l.each(func(limit ExchangeLimit) (Result, error) {
+		return limit.Limiter.Dump(ctx)
+	})
+}
+
+// Penalize blocks every rate limit until the given time, see FixedTruncatedWindowRateLimiter.Penalize
+func (l *ExchangeLimiter) Penalize(ctx context.Context, until time.Time) error {
+	for _, limit := range l.limits {
+		if err := limit.Limiter.Penalize(ctx, until); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// each calls fn on every rate limit, returning the longest time to wait and the fewest free slots. It stops on
+// errors other than ErrRateLimitExceeded.
+func (l *ExchangeLimiter) each(fn func(limit ExchangeLimit) (Result, error)) (Result, error) {
+	var (
+		r       Result
+		limited error
+	)
+
+	for i, limit := range l.limits {
+		lr, err := fn(limit)
+		if err != nil && !errors.Is(err, ErrRateLimitExceeded) {
+			return nores, err
+		}
+
+		if err != nil {
+			limited = err
+		}
+
+		if i == 0 || lr.FreeSlots < r.FreeSlots {
+			r.FreeSlots = lr.FreeSlots
+		}
+		r.TimeToWait = max(r.TimeToWait, lr.TimeToWait)
+	}
+
+	return r, limited
+}
+
+// NewExchangeLimits returns the rate limiters of the rate limits of an exchange, typically parsed by
+// ParseExchangeRateLimits, or an error if any of them is invalid. Rate limits of the same type are enforced
+// together by the ExchangeLimiter of their type, over fixed truncated windows of their interval.
+func NewExchangeLimits(args ExchangeLimitsArgs) (*ExchangeLimits, error) {
+	if args.Clock == nil {
+		args.Clock = NewClock()
+	}
+
+	if args.NewStorage == nil {
+		args.NewStorage = func(ExchangeRateLimit) fixedTruncatedWindowStorage {
+			return NewFixedTruncatedWindowMemoryStorageWithClock(args.Clock)
+		}
+	}
+
+	e := &ExchangeLimits{limiters: make(map[ExchangeLimitType]*ExchangeLimiter)}
+
+	for _, rl := range args.RateLimits {
+		rate, err := rl.rate()
+		if err != nil {
+			return nil, err
+		}
+
+		limiter := NewTokenFixedWindowRateLimiter(NewFixedTruncatedWindowRateLimiter(FixedTruncatedWindowArgs{
+			Capacity: rl.Limit,
+			Rate:     rate,
+			Clock:    args.Clock,
+			DB:       args.NewStorage(rl),
+		}))
+
+		l, ok := e.limiters[rl.RateLimitType]
+		if !ok {
+			l = &ExchangeLimiter{}
+			e.limiters[rl.RateLimitType] = l
+		}
+
+		for _, limit := range l.limits {
+			if limit.Key() == rl.Key() {
+				return nil, fmt.Errorf("exchange: duplicated rate limit %s", rl.Key())
+			}
+		}
+
+		l.limits = append(l.limits, ExchangeLimit{ExchangeRateLimit: rl, Rate: rate, Limiter: &limiter})
+	}
+
+	return e, nil
+}
@@ -0,0 +1,212 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+const exchangeInfo = `{
+  "timezone": "UTC",
+  "rateLimits": [
+    {"rateLimitType": "REQUEST_WEIGHT", "interval": "MINUTE", "intervalNum": 1, "limit": 1200},
+    {"rateLimitType": "ORDERS", "interval": "SECOND", "intervalNum": 10, "limit": 2},
+    {"rateLimitType": "ORDERS", "interval": "DAY", "intervalNum": 1, "limit": 3},
+    {"rateLimitType": "RAW_REQUESTS", "interval": "MINUTE", "intervalNum": 5, "limit": 6100}
+  ]
+}`
+
+func newExchangeLimits(t *testing.T, clock *pacemaker.TestClock, data string) *pacemaker.ExchangeLimits {
+	t.Helper()
+
+	rateLimits, err := pacemaker.ParseExchangeRateLimits([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	limits, err := pacemaker.NewExchangeLimits(pacemaker.ExchangeLimitsArgs{RateLimits: rateLimits, Clock: clock})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	return limits
+}
+
+func TestNewExchangeLimits_Rates(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	limits := newExchangeLimits(t, clock, exchangeInfo)
+
+	tests := []struct {
+		limitType     pacemaker.ExchangeLimitType
+		expectedRates []pacemaker.Rate
+	}{
+		{
+			limitType:     pacemaker.ExchangeLimitRequestWeight,
+			expectedRates: []pacemaker.Rate{{Amount: 1, Unit: time.Minute}},
+		},
+		{
+			limitType:     pacemaker.ExchangeLimitOrders,
+			expectedRates: []pacemaker.Rate{{Amount: 1, Unit: 10 * time.Second}, {Amount: 1, Unit: 24 * time.Hour}},
+		},
+		{
+			limitType:     pacemaker.ExchangeLimitRawRequests,
+			expectedRates: []pacemaker.Rate{{Amount: 5, Unit: time.Minute}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.limitType), func(t *testing.T) {
+			l, ok := limits.Limiter(test.limitType)
+			if !ok {
+				t.Fatalf("expected limiter of %s", test.limitType)
+			}
+
+			var rates []pacemaker.Rate
+			for _, limit := range l.Limits() {
+				rates = append(rates, limit.Rate)
+			}
+
+			if len(rates) != len(test.expectedRates) {
+				t.Fatalf("unexpected rates, want %v, have %v", test.expectedRates, rates)
+			}
+
+			for i := range rates {
+				if rates[i] != test.expectedRates[i] {
+					t.Errorf("unexpected rates, want %v, have %v", test.expectedRates, rates)
+				}
+			}
+		})
+	}
+
+	if _, ok := limits.Limiter("CONNECTIONS"); ok {
+		t.Error("unexpected limiter of a type missing from the exchange info")
+	}
+}
+
+func TestExchangeLimiter_Try(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	limits := newExchangeLimits(t, clock, exchangeInfo)
+	orders, _ := limits.Limiter(pacemaker.ExchangeLimitOrders)
+
+	steps := []struct {
+		name         string
+		forward      time.Duration
+		expectedRes  pacemaker.Result
+		expectedErr  error
+		expectedDump pacemaker.Result
+	}{
+		{
+			name:         "fits every window",
+			expectedRes:  pacemaker.Result{FreeSlots: 1},
+			expectedDump: pacemaker.Result{FreeSlots: 1},
+		},
+		{
+			name:         "fills the 10 seconds window",
+			expectedRes:  pacemaker.Result{FreeSlots: 0},
+			expectedDump: pacemaker.Result{FreeSlots: 0, TimeToWait: 7 * time.Second},
+		},
+		{
+			name:         "waits until the 10 seconds window aligned to the clock ends",
+			forward:      time.Second,
+			expectedRes:  pacemaker.Result{TimeToWait: 6 * time.Second},
+			expectedErr:  pacemaker.ErrRateLimitExceeded,
+			expectedDump: pacemaker.Result{FreeSlots: 0, TimeToWait: 6 * time.Second},
+		},
+		{
+			name:         "fills the daily window",
+			forward:      6 * time.Second,
+			expectedRes:  pacemaker.Result{FreeSlots: 0},
+			expectedDump: pacemaker.Result{FreeSlots: 0, TimeToWait: 13*time.Hour + 36*time.Minute + 30*time.Second},
+		},
+		{
+			name:         "waits for the longest window",
+			forward:      10 * time.Second,
+			expectedRes:  pacemaker.Result{TimeToWait: 13*time.Hour + 36*time.Minute + 20*time.Second},
+			expectedErr:  pacemaker.ErrRateLimitExceeded,
+			expectedDump: pacemaker.Result{FreeSlots: 0, TimeToWait: 13*time.Hour + 36*time.Minute + 20*time.Second},
+		},
+	}
+
+	for _, step := range steps {
+		clock.Forward(step.forward)
+
+		r, err := orders.Try(ctx, 1)
+		if !errors.Is(err, step.expectedErr) {
+			t.Errorf("%s: unexpected error, want %v, have %v", step.name, step.expectedErr, err)
+		}
+
+		if r != step.expectedRes {
+			t.Errorf("%s: unexpected result, want %+v, have %+v", step.name, step.expectedRes, r)
+		}
+
+		if d, _ := orders.Dump(ctx); d != step.expectedDump {
+			t.Errorf("%s: unexpected dump, want %+v, have %+v", step.name, step.expectedDump, d)
+		}
+	}
+}
+
+func TestExchangeLimits_UsageHeaders(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	limits := newExchangeLimits(t, clock, exchangeInfo)
+
+	headers := limits.UsageHeaders(map[pacemaker.ExchangeLimitType]string{
+		pacemaker.ExchangeLimitRequestWeight: "X-MBX-USED-WEIGHT-",
+		pacemaker.ExchangeLimitOrders:        "X-MBX-ORDER-COUNT-",
+	})
+
+	var names []string
+	for _, h := range headers {
+		names = append(names, h.Header)
+	}
+	sort.Strings(names)
+
+	expected := []string{"X-MBX-ORDER-COUNT-10S", "X-MBX-ORDER-COUNT-1D", "X-MBX-USED-WEIGHT-1M"}
+
+	if len(names) != len(expected) {
+		t.Fatalf("unexpected headers, want %v, have %v", expected, names)
+	}
+
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Errorf("unexpected headers, want %v, have %v", expected, names)
+		}
+	}
+}
+
+func TestNewExchangeLimits_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "invalid interval", data: `[{"rateLimitType": "ORDERS", "interval": "WEEK", "intervalNum": 1, "limit": 1}]`},
+		{name: "no interval number", data: `[{"rateLimitType": "ORDERS", "interval": "DAY", "limit": 1}]`},
+		{name: "no limit", data: `[{"rateLimitType": "ORDERS", "interval": "DAY", "intervalNum": 1}]`},
+		{
+			name: "duplicated",
+			data: `[{"rateLimitType": "ORDERS", "interval": "DAY", "intervalNum": 1, "limit": 1},
+				{"rateLimitType": "ORDERS", "interval": "DAY", "intervalNum": 1, "limit": 2}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rateLimits, err := pacemaker.ParseExchangeRateLimits([]byte(test.data))
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			if _, err := pacemaker.NewExchangeLimits(pacemaker.ExchangeLimitsArgs{RateLimits: rateLimits}); err == nil {
+				t.Error("expected error, have none")
+			}
+		})
+	}
+
+	if _, err := pacemaker.ParseExchangeRateLimits([]byte("{")); err == nil {
+		t.Error("expected error parsing malformed exchange info, have none")
+	}
+}
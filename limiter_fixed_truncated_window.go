@@ -2,6 +2,7 @@ package pacemaker
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
 )
@@ -42,6 +43,7 @@ type FixedTruncatedWindowRateLimiter struct {
 	window           time.Time
 	capacity         int64
 	rateLimitReached bool
+	penalty          penaltyBox
 }
 
 // Try returns how much time to wait to perform the request and an error indicating whether the rate limit
@@ -73,6 +75,10 @@ func (l *FixedTruncatedWindowRateLimiter) Dump(ctx context.Context) (r Result, e
 		l.window = now.Truncate(l.rate.TruncateDuration())
 	}
 
+	if ttw, err := l.penalty.wait(ctx, l.db, now); err != nil || ttw > 0 {
+		return res(ttw, 0), err
+	}
+
 	c, err := l.db.Get(ctx, l.window)
 
 	if c >= l.capacity {
@@ -96,6 +102,12 @@ func (l *FixedTruncatedWindowRateLimiter) try(ctx context.Context, tokens int64)
 
 	now := l.clock.Now()
 
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil {
+		return nores, err
+	} else if wait > 0 {
+		return res(wait, 0), ErrRateLimitExceeded
+	}
+
 	if TimeGTE(l.window.Add(l.rate.Duration()), now) {
 		l.rateLimitReached = false
 		l.window = now.Truncate(l.rate.TruncateDuration())
@@ -139,6 +151,12 @@ func (l *FixedTruncatedWindowRateLimiter) check(ctx context.Context, tokens int6
 
 	now := l.clock.Now()
 
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil {
+		return nores, err
+	} else if wait > 0 {
+		return res(wait, 0), ErrRateLimitExceeded
+	}
+
 	if TimeGTE(l.window.Add(l.rate.Duration()), now) {
 		// new window so no rate Limit
 		l.rateLimitReached = false
@@ -172,6 +190,125 @@ func (l *FixedTruncatedWindowRateLimiter) check(ctx context.Context, tokens int6
 	return res(ttw, l.capacity-c), ErrRateLimitExceeded
 }
 
+// Snapshot returns the state of the current window, to be restored with Restore, e.g. after a restart. Unlike
+// storage snapshots, it works with any storage, as the counter is read from it.
+func (l *FixedTruncatedWindowRateLimiter) Snapshot(ctx context.Context) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	end := l.window.Add(l.rate.Duration())
+
+	var windows []snapshotWindow
+
+	if !l.window.IsZero() && end.After(now) {
+		c, err := l.db.Get(ctx, l.window)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, snapshotWindow{
+			Window:  l.window,
+			Counter: c,
+			TTL:     end.Sub(now),
+		})
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Restore resumes the window of a snapshot taken by Snapshot, adding its counter to the storage. Therefore, it
+// is meant to be called on a fresh limiter, before serving any request. Windows over in the meantime are dropped.
+func (l *FixedTruncatedWindowRateLimiter) Restore(ctx context.Context, data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	snap, err := decodeSnapshot(data, now)
+	if err != nil {
+		return err
+	}
+
+	w, ok := snap.latest()
+	if !ok {
+		return nil
+	}
+
+	if w.Counter > 0 {
+		_, err = l.db.Inc(ctx, FixedWindowIncArgs{
+			Window:   w.Window,
+			Tokens:   w.Counter,
+			Capacity: math.MaxInt64,
+			TTL:      snap.expiresAt(w).Sub(now),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	l.window = w.Window
+	l.rateLimitReached = false
+
+	return nil
+}
+
+// Sync raises the counter of the current window to the usage reported by the server at the given time, e.g. from
+// a response header, unless it is higher already. It corrects the drift caused by other processes, restarts or
+// clock skew. Usage reported at any other window is ignored.
+func (l *FixedTruncatedWindowRateLimiter) Sync(ctx context.Context, used int64, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	if TimeGTE(l.window.Add(l.rate.Duration()), now) {
+		l.rateLimitReached = false
+		l.window = now.Truncate(l.rate.TruncateDuration())
+	}
+
+	end := l.window.Add(l.rate.Duration())
+
+	if at.Before(l.window) || !at.Before(end) {
+		return nil
+	}
+
+	c, err := syncCounter(ctx, l.db, FixedWindowSyncArgs{
+		Window:  l.window,
+		TTL:     end.Sub(now),
+		Counter: used,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c >= l.capacity {
+		l.rateLimitReached = true
+	}
+
+	return nil
+}
+
+// sameWindow returns whether a and b fall in the current window. Windows not started yet, or over, match nothing.
+func (l *FixedTruncatedWindowRateLimiter) sameWindow(a, b time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	end := l.window.Add(l.rate.Duration())
+
+	return !l.window.IsZero() && inWindow(a, l.window, end) && inWindow(b, l.window, end)
+}
+
+// Penalize blocks the limiter until the given time, e.g. when the server replies 429 Too Many Requests, every Try
+// and Check failing with ErrRateLimitExceeded meanwhile. Storages supporting penalties, such as
+// FixedWindowRedisStorage, share it with every limiter using them.
+func (l *FixedTruncatedWindowRateLimiter) Penalize(ctx context.Context, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.penalty.penalize(ctx, l.db, until, l.clock.Now())
+}
+
 func (l *FixedTruncatedWindowRateLimiter) fixedWindow() {}
 
 // NewFixedTruncatedWindowRateLimiter returns a new instance of FixedTruncatedWindowRateLimiter from struct of args
@@ -193,9 +330,11 @@ func NewFixedTruncatedWindowRateLimiter(
 // with the state of rate limits at the server
 type FixedTruncatedWindowMemoryStorage struct {
 	mu             sync.Mutex
+	clock          clock
 	previousWindow time.Time
 	counter        int64
 	ttl            time.Duration
+	expiresAt      time.Time
 }
 
 func (s *FixedTruncatedWindowMemoryStorage) Inc(
@@ -212,6 +351,7 @@ func (s *FixedTruncatedWindowMemoryStorage) Inc(
 	counter := args.Tokens + s.counter
 	s.counter = min(counter, args.Capacity)
 	s.ttl = args.TTL
+	s.expiresAt = s.now().Add(args.TTL)
 
 	return counter, ctx.Err()
 }
@@ -230,7 +370,95 @@ func (s *FixedTruncatedWindowMemoryStorage) Get(
 	return s.counter, ctx.Err()
 }
 
+// Sync raises the counter of window to the one of args, unless it is higher already
+func (s *FixedTruncatedWindowMemoryStorage) Sync(
+	ctx context.Context,
+	args FixedWindowSyncArgs,
+) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.previousWindow.Equal(args.Window) {
+		s.previousWindow = args.Window
+		s.counter = 0
+	}
+
+	if args.Counter > s.counter {
+		s.counter = args.Counter
+		s.ttl = args.TTL
+		s.expiresAt = s.now().Add(args.TTL)
+	}
+
+	return s.counter, ctx.Err()
+}
+
+// Snapshot returns the state of the storage, to be restored with Restore, e.g. after a restart. Its window
+// is only included while its TTL has not elapsed.
+func (s *FixedTruncatedWindowMemoryStorage) Snapshot(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var windows []snapshotWindow
+
+	if s.expiresAt.After(now) {
+		windows = append(windows, snapshotWindow{
+			Window:  s.previousWindow,
+			Counter: s.counter,
+			TTL:     s.expiresAt.Sub(now),
+		})
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Restore replaces the state of the storage by the one of a snapshot taken by Snapshot. Windows whose TTL
+// elapsed in the meantime are dropped. Only the latest window is kept.
+func (s *FixedTruncatedWindowMemoryStorage) Restore(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, err := decodeSnapshot(data, s.now())
+	if err != nil {
+		return err
+	}
+
+	w, ok := snap.latest()
+	if !ok {
+		return nil
+	}
+
+	s.previousWindow = w.Window
+	s.counter = w.Counter
+	s.ttl = w.TTL
+	s.expiresAt = snap.expiresAt(w)
+
+	return nil
+}
+
+// now tolerates storages not created by NewFixedTruncatedWindowMemoryStorage
+func (s *FixedTruncatedWindowMemoryStorage) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
 // NewFixedTruncatedWindowMemoryStorage returns a new instance of FixedTruncatedWindowMemoryStorage
 func NewFixedTruncatedWindowMemoryStorage() *FixedTruncatedWindowMemoryStorage {
-	return &FixedTruncatedWindowMemoryStorage{}
+	return NewFixedTruncatedWindowMemoryStorageWithClock(NewClock())
+}
+
+// NewFixedTruncatedWindowMemoryStorageWithClock returns a new instance of FixedTruncatedWindowMemoryStorage expiring its windows by clock, which
+// must be the one of its rate limiter when it is not RealClock, e.g. a TestClock
+func NewFixedTruncatedWindowMemoryStorageWithClock(clock clock) *FixedTruncatedWindowMemoryStorage {
+	return &FixedTruncatedWindowMemoryStorage{clock: clock}
 }
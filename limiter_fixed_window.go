@@ -3,6 +3,7 @@ package pacemaker
 import (
 	"context"
 	"errors"
+	"math"
 	"sync"
 	"time"
 )
@@ -42,6 +43,8 @@ type FixedWindowRateLimiter struct {
 	capacity int64
 
 	rateLimitReached bool
+
+	penalty penaltyBox
 }
 
 func (l *FixedWindowRateLimiter) Try(ctx context.Context) (Result, error) {
@@ -63,6 +66,10 @@ func (l *FixedWindowRateLimiter) Dump(ctx context.Context) (Result, error) {
 
 	now := l.clock.Now()
 
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil || wait > 0 {
+		return res(wait, 0), err
+	}
+
 	ttw := l.deadline.Sub(now)
 
 	var (
@@ -102,6 +109,12 @@ func (l *FixedWindowRateLimiter) try(ctx context.Context, tokens int64) (Result,
 
 	now := l.clock.Now()
 
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil {
+		return nores, err
+	} else if wait > 0 {
+		return res(wait, 0), ErrRateLimitExceeded
+	}
+
 	l.process(now)
 
 	ttw := l.deadline.Sub(now)
@@ -151,6 +164,12 @@ func (l *FixedWindowRateLimiter) check(ctx context.Context, tokens int64) (Resul
 
 	now := l.clock.Now()
 
+	if wait, err := l.penalty.wait(ctx, l.db, now); err != nil {
+		return nores, err
+	} else if wait > 0 {
+		return res(wait, 0), ErrRateLimitExceeded
+	}
+
 	l.process(now)
 
 	ttw := l.deadline.Sub(now)
@@ -224,6 +243,127 @@ func (l *FixedWindowRateLimiter) fillDeadline(ctx context.Context) error {
 	return nil
 }
 
+// Snapshot returns the state of the current window, to be restored with Restore, e.g. after a restart. Unlike
+// storage snapshots, it works with any storage, as the counter is read from it.
+func (l *FixedWindowRateLimiter) Snapshot(ctx context.Context) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.fillDeadline(ctx); err != nil {
+		return nil, err
+	}
+
+	now := l.clock.Now()
+
+	var windows []snapshotWindow
+
+	if l.deadline.After(now) {
+		c, err := l.db.Get(ctx, l.deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, snapshotWindow{
+			Window:  l.deadline,
+			Counter: c,
+			TTL:     l.deadline.Sub(now),
+		})
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Restore resumes the window of a snapshot taken by Snapshot, adding its counter to the storage. Therefore, it
+// is meant to be called on a fresh limiter, before serving any request. Windows over in the meantime are dropped.
+func (l *FixedWindowRateLimiter) Restore(ctx context.Context, data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+
+	snap, err := decodeSnapshot(data, now)
+	if err != nil {
+		return err
+	}
+
+	w, ok := snap.latest()
+	if !ok {
+		return nil
+	}
+
+	if w.Counter > 0 {
+		_, err = l.db.Inc(ctx, FixedWindowIncArgs{
+			Window:   w.Window,
+			Tokens:   w.Counter,
+			Capacity: math.MaxInt64,
+			TTL:      snap.expiresAt(w).Sub(now),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	l.deadline = w.Window
+	l.rateLimitReached = false
+
+	return nil
+}
+
+// Sync raises the counter of the current window to the usage reported by the server at the given time, e.g. from
+// a response header, unless it is higher already. It corrects the drift caused by other processes, restarts or
+// clock skew. Usage reported at any other window is ignored.
+func (l *FixedWindowRateLimiter) Sync(ctx context.Context, used int64, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.fillDeadline(ctx); err != nil {
+		return err
+	}
+
+	now := l.clock.Now()
+
+	l.process(now)
+
+	if at.Before(l.deadline.Add(-l.rate.Duration())) || !at.Before(l.deadline) {
+		return nil
+	}
+
+	c, err := syncCounter(ctx, l.db, FixedWindowSyncArgs{
+		Window:  l.deadline,
+		TTL:     l.deadline.Sub(now),
+		Counter: used,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c >= l.capacity {
+		l.rateLimitReached = true
+	}
+
+	return nil
+}
+
+// sameWindow returns whether a and b fall in the current window. Windows not started yet, or over, match nothing.
+func (l *FixedWindowRateLimiter) sameWindow(a, b time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := l.deadline.Add(-l.rate.Duration())
+
+	return inWindow(a, start, l.deadline) && inWindow(b, start, l.deadline)
+}
+
+// Penalize blocks the limiter until the given time, e.g. when the server replies 429 Too Many Requests, every Try
+// and Check failing with ErrRateLimitExceeded meanwhile. Storages supporting penalties, such as
+// FixedWindowRedisStorage, share it with every limiter using them.
+func (l *FixedWindowRateLimiter) Penalize(ctx context.Context, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.penalty.penalize(ctx, l.db, until, l.clock.Now())
+}
+
 func (l *FixedWindowRateLimiter) fixedWindow() {}
 
 // NewFixedWindowRateLimiter returns a new instance of FixedWindowRateLimiter from struct of args
@@ -241,10 +381,12 @@ func NewFixedWindowRateLimiter(args FixedWindowArgs) *FixedWindowRateLimiter {
 // with standalone instances of your program and do not care about it restarting and not being exactly compliant with
 // servers rate limits
 type FixedWindowMemoryStorage struct {
-	mu       sync.Mutex
-	counter  int64
-	deadline time.Time
-	ttl      time.Duration
+	mu        sync.Mutex
+	clock     clock
+	counter   int64
+	deadline  time.Time
+	ttl       time.Duration
+	expiresAt time.Time
 }
 
 func (s *FixedWindowMemoryStorage) Inc(
@@ -261,6 +403,7 @@ func (s *FixedWindowMemoryStorage) Inc(
 
 	s.counter += args.Tokens
 	s.ttl = args.TTL
+	s.expiresAt = s.now().Add(args.TTL)
 
 	return s.counter, ctx.Err()
 }
@@ -277,6 +420,28 @@ func (s *FixedWindowMemoryStorage) Get(ctx context.Context, window time.Time) (i
 	return s.counter, ctx.Err()
 }
 
+// Sync raises the counter of window to the one of args, unless it is higher already
+func (s *FixedWindowMemoryStorage) Sync(
+	ctx context.Context,
+	args FixedWindowSyncArgs,
+) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.deadline.Equal(args.Window) {
+		s.deadline = args.Window
+		s.counter = 0
+	}
+
+	if args.Counter > s.counter {
+		s.counter = args.Counter
+		s.ttl = args.TTL
+		s.expiresAt = s.now().Add(args.TTL)
+	}
+
+	return s.counter, ctx.Err()
+}
+
 func (s *FixedWindowMemoryStorage) LastWindow(ctx context.Context) (time.Time, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -284,7 +449,74 @@ func (s *FixedWindowMemoryStorage) LastWindow(ctx context.Context) (time.Time, e
 	return s.deadline, ctx.Err()
 }
 
+// Snapshot returns the state of the storage, to be restored with Restore, e.g. after a restart. Its window
+// is only included while its TTL has not elapsed.
+func (s *FixedWindowMemoryStorage) Snapshot(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var windows []snapshotWindow
+
+	if s.expiresAt.After(now) {
+		windows = append(windows, snapshotWindow{
+			Window:  s.deadline,
+			Counter: s.counter,
+			TTL:     s.expiresAt.Sub(now),
+		})
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Restore replaces the state of the storage by the one of a snapshot taken by Snapshot. Windows whose TTL
+// elapsed in the meantime are dropped. Only the latest window is kept.
+func (s *FixedWindowMemoryStorage) Restore(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, err := decodeSnapshot(data, s.now())
+	if err != nil {
+		return err
+	}
+
+	w, ok := snap.latest()
+	if !ok {
+		return nil
+	}
+
+	s.deadline = w.Window
+	s.counter = w.Counter
+	s.ttl = w.TTL
+	s.expiresAt = snap.expiresAt(w)
+
+	return nil
+}
+
+// now tolerates storages not created by NewFixedWindowMemoryStorage
+func (s *FixedWindowMemoryStorage) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
 // NewFixedWindowMemoryStorage returns a new instance of FixedWindowMemoryStorage
 func NewFixedWindowMemoryStorage() *FixedWindowMemoryStorage {
-	return &FixedWindowMemoryStorage{}
+	return NewFixedWindowMemoryStorageWithClock(NewClock())
+}
+
+// NewFixedWindowMemoryStorageWithClock returns a new instance of FixedWindowMemoryStorage expiring its windows by clock, which
+// must be the one of its rate limiter when it is not RealClock, e.g. a TestClock
+func NewFixedWindowMemoryStorageWithClock(clock clock) *FixedWindowMemoryStorage {
+	return &FixedWindowMemoryStorage{clock: clock}
 }
@@ -0,0 +1,88 @@
+package pacemaker
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+type KeyedRateLimiterArgs struct {
+	// New returns the rate limiter of key. Its storage must be keyed as well, typically through
+	// KeyedMemoryStorage.For(key), or by key prefix.
+	New func(key string) TokenFixedWindowRateLimiter
+	// MaxKeys bounds how many rate limiters are kept. Zero means unbounded. The least recently used rate limiters
+	// are dropped to make room, to be created again by New when needed. Their state is recovered from storage,
+	// thus the bound only trades memory for allocations.
+	MaxKeys int
+}
+
+// KeyedRateLimiter holds a rate limiter per key, e.g. per user or IP address, created on first use
+type KeyedRateLimiter struct {
+	args KeyedRateLimiterArgs
+
+	mu       sync.Mutex
+	limiters map[string]*list.Element
+	// lru holds the keyed limiters, most recently used first
+	lru *list.List
+}
+
+type keyedLimiter struct {
+	key     string
+	limiter TokenFixedWindowRateLimiter
+}
+
+// Try consumes tokens from the rate limit of key, see TokenFixedWindowRateLimiter.Try
+func (l *KeyedRateLimiter) Try(ctx context.Context, key string, tokens int64) (Result, error) {
+	limiter := l.limiter(key)
+	return limiter.Try(ctx, tokens)
+}
+
+// Check returns whether tokens fit in the rate limit of key, see TokenFixedWindowRateLimiter.Check
+func (l *KeyedRateLimiter) Check(ctx context.Context, key string, tokens int64) (Result, error) {
+	limiter := l.limiter(key)
+	return limiter.Check(ctx, tokens)
+}
+
+// Dump returns the state of the rate limit of key, see TokenFixedWindowRateLimiter.Dump
+func (l *KeyedRateLimiter) Dump(ctx context.Context, key string) (Result, error) {
+	limiter := l.limiter(key)
+	return limiter.Dump(ctx)
+}
+
+// Len returns the number of rate limiters held
+func (l *KeyedRateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.limiters)
+}
+
+func (l *KeyedRateLimiter) limiter(key string) TokenFixedWindowRateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.limiters[key]; ok {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*keyedLimiter).limiter
+	}
+
+	if l.args.MaxKeys > 0 && len(l.limiters) >= l.args.MaxKeys {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.limiters, oldest.Value.(*keyedLimiter).key)
+	}
+
+	kl := &keyedLimiter{key: key, limiter: l.args.New(key)}
+	l.limiters[key] = l.lru.PushFront(kl)
+
+	return kl.limiter
+}
+
+// NewKeyedRateLimiter returns a new instance of KeyedRateLimiter from struct of args
+func NewKeyedRateLimiter(args KeyedRateLimiterArgs) *KeyedRateLimiter {
+	return &KeyedRateLimiter{
+		args:     args,
+		limiters: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
@@ -0,0 +1,31 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+func TestKeyedRateLimiter_MaxKeys(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	l := newKeyedLimiter(clock, 1, 2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := l.Try(ctx, key, 1); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	if l.Len() != 2 {
+		t.Errorf("unexpected keys, want 2, have %d", l.Len())
+	}
+
+	// The evicted limiter of "a" recovers its state from storage
+	if _, err := l.Try(ctx, "a", 1); !errors.Is(err, pacemaker.ErrRateLimitExceeded) {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrRateLimitExceeded, err)
+	}
+}
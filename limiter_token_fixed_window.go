@@ -2,6 +2,7 @@ package pacemaker
 
 import (
 	"context"
+	"time"
 )
 
 type (
@@ -13,6 +14,11 @@ type (
 		rateLimit
 		try(ctx context.Context, tokens int64) (Result, error)
 		check(ctx context.Context, tokens int64) (Result, error)
+		Snapshot(ctx context.Context) ([]byte, error)
+		Restore(ctx context.Context, data []byte) error
+		Sync(ctx context.Context, used int64, at time.Time) error
+		Penalize(ctx context.Context, until time.Time) error
+		sameWindow(a, b time.Time) bool
 		fixedWindow()
 	}
 )
@@ -41,6 +47,30 @@ func (l *TokenFixedWindowRateLimiter) Dump(ctx context.Context) (Result, error)
 	return l.inner.Dump(ctx)
 }
 
+// Snapshot returns the state of the inner rate limiter, to be restored with Restore
+func (l *TokenFixedWindowRateLimiter) Snapshot(ctx context.Context) ([]byte, error) {
+	return l.inner.Snapshot(ctx)
+}
+
+// Restore resumes the state of the inner rate limiter from a snapshot taken by Snapshot
+func (l *TokenFixedWindowRateLimiter) Restore(ctx context.Context, data []byte) error {
+	return l.inner.Restore(ctx, data)
+}
+
+// Sync raises the usage of the inner rate limiter to the one reported by the server, see its Sync method
+func (l *TokenFixedWindowRateLimiter) Sync(ctx context.Context, used int64, at time.Time) error {
+	return l.inner.Sync(ctx, used, at)
+}
+
+func (l *TokenFixedWindowRateLimiter) sameWindow(a, b time.Time) bool {
+	return l.inner.sameWindow(a, b)
+}
+
+// Penalize blocks the inner rate limiter until the given time, see its Penalize method
+func (l *TokenFixedWindowRateLimiter) Penalize(ctx context.Context, until time.Time) error {
+	return l.inner.Penalize(ctx, until)
+}
+
 // NewTokenFixedWindowRateLimiter returns a new instance of TokenFixedWindowRateLimiter by receiving an already
 // created fixed-window rate limiter as argument.
 func NewTokenFixedWindowRateLimiter(inner fixedWindowRateLimiter) TokenFixedWindowRateLimiter {
@@ -0,0 +1,183 @@
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+type (
+	// httpLimiter consumes tokens from the rate limit of a key, such as KeyedRateLimiter
+	httpLimiter interface {
+		Try(ctx context.Context, key string, tokens int64) (Result, error)
+	}
+
+	// RateLimitHeaders is the set of headers NewHTTPMiddleware reports rate limits with
+	RateLimitHeaders int
+
+	// SharedHTTPLimiter limits every request by the same rate limiter, whatever its key
+	SharedHTTPLimiter struct {
+		limiter tokenLimiter
+	}
+
+	HTTPMiddlewareOpts struct {
+		// Limiter is either keyed, such as KeyedRateLimiter, or shared by every request through
+		// NewSharedHTTPLimiter, e.g. a TokenFixedWindowRateLimiter
+		Limiter httpLimiter
+		// Key returns the key to limit the request by. Defaults to the host of the client address.
+		Key func(r *http.Request) string
+		// Cost returns how many tokens the request consumes. Defaults to 1.
+		Cost func(r *http.Request) int64
+		// Limit is the capacity of the limiter, reported as the limit of the headers. Omitted if zero.
+		Limit int64
+		// Rate is the rate of the limiter. If set, the reset of the headers is reported for accepted requests as
+		// well, assuming windows aligned to the clock, as FixedTruncatedWindowRateLimiter does. Otherwise, it is
+		// only reported for rejected requests.
+		Rate Rate
+		// Headers defaults to RateLimitHeadersDraft
+		Headers RateLimitHeaders
+		// OnReject writes the response of rejected requests, once Retry-After and the rate limit headers are set.
+		// Requests costing more than the capacity of the limiter are rejected as well, with no Retry-After as
+		// waiting does not help. Defaults to 429 Too Many Requests.
+		OnReject func(w http.ResponseWriter, r *http.Request, res Result)
+		// OnError writes the response of requests whose limit cannot be checked, e.g. because the storage is
+		// down. Defaults to 500 Internal Server Error.
+		OnError func(w http.ResponseWriter, r *http.Request, err error)
+		// Clock defaults to RealClock
+		Clock clock
+	}
+)
+
+const (
+	// RateLimitHeadersDraft reports RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset, as the IETF draft
+	// does, the reset being the seconds until the window ends
+	RateLimitHeadersDraft RateLimitHeaders = iota
+	// RateLimitHeadersLegacy reports X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset, the reset
+	// being the unix time the window ends at, in seconds
+	RateLimitHeadersLegacy
+	// RateLimitHeadersNone reports Retry-After alone
+	RateLimitHeadersNone
+)
+
+// NewHTTPMiddleware returns a middleware limiting the requests to the handler it wraps, e.g.
+//
+//	limiter := pacemaker.NewKeyedRateLimiter(...)
+//	mux.Handle("/api/", pacemaker.NewHTTPMiddleware(pacemaker.HTTPMiddlewareOpts{Limiter: limiter})(api))
+//
+// Rejected requests are replied with Retry-After, along the rate limit headers of every request.
+func NewHTTPMiddleware(opts HTTPMiddlewareOpts) func(http.Handler) http.Handler {
+	if opts.Key == nil {
+		opts.Key = clientHost
+	}
+
+	if opts.Cost == nil {
+		opts.Cost = func(*http.Request) int64 { return 1 }
+	}
+
+	if opts.OnReject == nil {
+		opts.OnReject = func(w http.ResponseWriter, _ *http.Request, _ Result) {
+			http.Error(w, ErrRateLimitExceeded.Error(), http.StatusTooManyRequests)
+		}
+	}
+
+	if opts.OnError == nil {
+		opts.OnError = func(w http.ResponseWriter, _ *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := opts.Limiter.Try(r.Context(), opts.Key(r), opts.Cost(r))
+
+			tooCostly := errors.Is(err, ErrTokensGreaterThanCapacity)
+			limited := tooCostly || errors.Is(err, ErrRateLimitExceeded)
+
+			if err != nil && !limited {
+				opts.OnError(w, r, err)
+				return
+			}
+
+			setRateLimitHeaders(w.Header(), opts, res, limited)
+
+			if limited {
+				if !tooCostly {
+					w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(res.TimeToWait), 10))
+				}
+				opts.OnReject(w, r, res)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setRateLimitHeaders(h http.Header, opts HTTPMiddlewareOpts, res Result, limited bool) {
+	if opts.Headers == RateLimitHeadersNone {
+		return
+	}
+
+	prefix := "RateLimit-"
+	if opts.Headers == RateLimitHeadersLegacy {
+		prefix = "X-RateLimit-"
+	}
+
+	if opts.Limit > 0 {
+		h.Set(prefix+"Limit", strconv.FormatInt(opts.Limit, 10))
+	}
+
+	remaining := max(res.FreeSlots, 0)
+	if limited {
+		remaining = 0
+	}
+	h.Set(prefix+"Remaining", strconv.FormatInt(remaining, 10))
+
+	now := opts.Clock.Now()
+	end := now.Add(res.TimeToWait)
+
+	if !limited {
+		window := opts.Rate.TruncateDuration()
+		if window <= 0 {
+			return
+		}
+		end = now.Truncate(window).Add(opts.Rate.Duration())
+	}
+
+	if opts.Headers == RateLimitHeadersLegacy {
+		reset := end.Unix()
+		if end.Nanosecond() > 0 {
+			reset++
+		}
+		h.Set(prefix+"Reset", strconv.FormatInt(reset, 10))
+		return
+	}
+
+	h.Set(prefix+"Reset", strconv.FormatInt(ceilSeconds(end.Sub(now)), 10))
+}
+
+// Try consumes tokens from the shared rate limiter, ignoring the key
+func (l SharedHTTPLimiter) Try(ctx context.Context, _ string, tokens int64) (Result, error) {
+	return l.limiter.Try(ctx, tokens)
+}
+
+// NewSharedHTTPLimiter returns a new instance of SharedHTTPLimiter, to limit every request by limiter, such as a
+// TokenFixedWindowRateLimiter
+func NewSharedHTTPLimiter(limiter tokenLimiter) SharedHTTPLimiter {
+	return SharedHTTPLimiter{limiter: limiter}
+}
+
+// clientHost returns the host of the client address of r
+func clientHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,257 @@
+package pacemaker_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cost := func(r *http.Request) int64 {
+		n, _ := strconv.ParseInt(r.URL.Query().Get("cost"), 10, 64)
+		return n
+	}
+
+	type step struct {
+		path            string
+		remoteAddr      string
+		forward         time.Duration
+		expectedStatus  int
+		expectedHeaders map[string]string
+	}
+
+	tests := []struct {
+		name    string
+		headers pacemaker.RateLimitHeaders
+		steps   []step
+	}{
+		{
+			name:    "draft headers",
+			headers: pacemaker.RateLimitHeadersDraft,
+			steps: []step{
+				{
+					path:           "/?cost=2",
+					forward:        15 * time.Second,
+					expectedStatus: http.StatusNoContent,
+					expectedHeaders: map[string]string{
+						"RateLimit-Limit": "3", "RateLimit-Remaining": "1", "RateLimit-Reset": "45", "Retry-After": "",
+					},
+				},
+				{
+					path:           "/?cost=2",
+					forward:        15 * time.Second,
+					expectedStatus: http.StatusTooManyRequests,
+					expectedHeaders: map[string]string{
+						"RateLimit-Limit": "3", "RateLimit-Remaining": "0", "RateLimit-Reset": "30", "Retry-After": "30",
+					},
+				},
+				{
+					path:           "/?cost=1",
+					remoteAddr:     "10.0.0.2:1234",
+					expectedStatus: http.StatusNoContent,
+					expectedHeaders: map[string]string{
+						"RateLimit-Remaining": "2", "RateLimit-Reset": "30",
+					},
+				},
+				{
+					path:           "/?cost=1",
+					forward:        30 * time.Second,
+					expectedStatus: http.StatusNoContent,
+					expectedHeaders: map[string]string{
+						"RateLimit-Remaining": "2", "RateLimit-Reset": "60",
+					},
+				},
+			},
+		},
+		{
+			name:    "legacy headers",
+			headers: pacemaker.RateLimitHeadersLegacy,
+			steps: []step{
+				{
+					path:           "/?cost=3",
+					expectedStatus: http.StatusNoContent,
+					expectedHeaders: map[string]string{
+						"X-RateLimit-Limit":     "3",
+						"X-RateLimit-Remaining": "0",
+						"X-RateLimit-Reset":     "1644056640",
+						"RateLimit-Limit":       "",
+					},
+				},
+				{
+					path:           "/",
+					forward:        20 * time.Second,
+					expectedStatus: http.StatusTooManyRequests,
+					expectedHeaders: map[string]string{
+						"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1644056640", "Retry-After": "40",
+					},
+				},
+			},
+		},
+		{
+			name:    "no headers",
+			headers: pacemaker.RateLimitHeadersNone,
+			steps: []step{
+				{
+					path:            "/?cost=3",
+					expectedStatus:  http.StatusNoContent,
+					expectedHeaders: map[string]string{"RateLimit-Remaining": "", "X-RateLimit-Remaining": ""},
+				},
+				{
+					path:            "/",
+					expectedStatus:  http.StatusTooManyRequests,
+					expectedHeaders: map[string]string{"RateLimit-Remaining": "", "Retry-After": "60"},
+				},
+				{
+					path:            "/?cost=4",
+					expectedStatus:  http.StatusTooManyRequests,
+					expectedHeaders: map[string]string{"Retry-After": ""},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+			handler := pacemaker.NewHTTPMiddleware(pacemaker.HTTPMiddlewareOpts{
+				Limiter: newKeyedLimiter(clock, 3, 0),
+				Cost:    cost,
+				Limit:   3,
+				Rate:    pacemaker.Rate{Amount: 1, Unit: time.Minute},
+				Headers: test.headers,
+				Clock:   clock,
+			})(ok)
+
+			for i, step := range test.steps {
+				clock.Forward(step.forward)
+
+				r := httptest.NewRequest(http.MethodGet, step.path, nil)
+				if step.remoteAddr != "" {
+					r.RemoteAddr = step.remoteAddr
+				}
+				w := httptest.NewRecorder()
+
+				handler.ServeHTTP(w, r)
+
+				if w.Code != step.expectedStatus {
+					t.Errorf("step %d: unexpected status, want %d, have %d", i, step.expectedStatus, w.Code)
+				}
+
+				for header, expected := range step.expectedHeaders {
+					if have := w.Header().Get(header); have != expected {
+						t.Errorf("step %d: unexpected %s, want %q, have %q", i, header, expected, have)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPMiddleware_Handlers(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	faulty := pacemakertest.NewFaultyStorage(
+		pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock}).For("faulty"),
+		pacemakertest.FaultConfig{ErrorRate: 1},
+	)
+
+	limiter := pacemaker.NewKeyedRateLimiter(pacemaker.KeyedRateLimiterArgs{
+		New: func(string) pacemaker.TokenFixedWindowRateLimiter {
+			return pacemaker.NewTokenFixedWindowRateLimiter(
+				pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+					Capacity: 1,
+					Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+					Clock:    clock,
+					DB:       faulty,
+				}),
+			)
+		},
+	})
+
+	var handledErr error
+
+	handler := pacemaker.NewHTTPMiddleware(pacemaker.HTTPMiddlewareOpts{
+		Limiter: limiter,
+		OnError: func(w http.ResponseWriter, _ *http.Request, err error) {
+			handledErr = err
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+		Clock: clock,
+	})(http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status, want %d, have %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if !errors.Is(handledErr, pacemakertest.ErrInjected) {
+		t.Errorf("unexpected error, want %v, have %v", pacemakertest.ErrInjected, handledErr)
+	}
+
+	rejected := false
+
+	handler = pacemaker.NewHTTPMiddleware(pacemaker.HTTPMiddlewareOpts{
+		Limiter: newKeyedLimiter(clock, 1, 0),
+		OnReject: func(w http.ResponseWriter, _ *http.Request, res pacemaker.Result) {
+			rejected = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+		Clock: clock,
+	})(http.NotFoundHandler())
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if !rejected {
+		t.Error("expected the custom rejection handler to be called")
+	}
+}
+
+func TestHTTPMiddleware_SharedLimiter(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	limiter := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+			Capacity: 1,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock),
+		}),
+	)
+
+	handler := pacemaker.NewHTTPMiddleware(pacemaker.HTTPMiddlewareOpts{
+		Limiter: pacemaker.NewSharedHTTPLimiter(&limiter),
+		Clock:   clock,
+	})(http.NotFoundHandler())
+
+	for i, addr := range []string{"10.0.0.1:1234", "10.0.0.2:1234"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		expected := http.StatusNotFound
+		if i > 0 {
+			expected = http.StatusTooManyRequests
+		}
+
+		if w.Code != expected {
+			t.Errorf("request from %s: unexpected status, want %d, have %d", addr, expected, w.Code)
+		}
+	}
+}
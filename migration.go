@@ -0,0 +1,144 @@
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+type (
+	// snapshotSource is implemented by storages able to list their live windows
+	snapshotSource interface {
+		Snapshot(ctx context.Context) ([]byte, error)
+	}
+
+	// lastWindowSource is implemented by storages backing FixedWindowRateLimiter, which only expose their
+	// latest window
+	lastWindowSource interface {
+		Get(ctx context.Context, window time.Time) (int64, error)
+		LastWindow(ctx context.Context) (time.Time, error)
+	}
+
+	MigrateOpts struct {
+		// DryRun reports what would be migrated, without writing to the destination
+		DryRun bool
+		// Clock is used to compute the remaining TTL of windows. Defaults to RealClock.
+		Clock clock
+	}
+
+	// MigrationReport describes the windows copied, or to be copied on dry runs, by Migrate
+	MigrationReport struct {
+		DryRun  bool
+		Windows []MigratedWindow
+	}
+
+	// MigratedWindow is a live window of the source storage
+	MigratedWindow struct {
+		Window  time.Time
+		Counter int64
+		TTL     time.Duration
+		// Existing is the counter the destination held for the window before migrating. It is added to, thus
+		// migrating twice counts the window twice.
+		Existing int64
+	}
+)
+
+// Migrate copies the live windows of src to dst, e.g. to change the prefix of a redis storage or to move from
+// memory to redis without losing the current windows. Windows are listed with the Snapshot method of src, if it
+// has one. Otherwise, src must have LastWindow, as storages of FixedWindowRateLimiter do, and only its latest window
+// is copied: as those windows are the deadline of the window, their TTL is the time left until them. Counters are
+// added to dst regardless of its capacity, with the TTL left in src. Limiters keeping state in memory, such as the
+// current window, must be restarted after migrating.
+func Migrate(
+	ctx context.Context,
+	src fixedTruncatedWindowStorage,
+	dst fixedTruncatedWindowStorage,
+	opts MigrateOpts,
+) (report MigrationReport, err error) {
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	report.DryRun = opts.DryRun
+
+	var windows []snapshotWindow
+
+	windows, err = liveWindows(ctx, src, opts.Clock.Now())
+	if err != nil {
+		return
+	}
+
+	for _, w := range windows {
+		m := MigratedWindow{Window: w.Window, Counter: w.Counter, TTL: w.TTL}
+
+		m.Existing, err = dst.Get(ctx, w.Window)
+		if err != nil {
+			return
+		}
+
+		if !opts.DryRun && w.Counter > 0 {
+			_, err = dst.Inc(ctx, FixedWindowIncArgs{
+				Window:   w.Window,
+				TTL:      w.TTL,
+				Tokens:   w.Counter,
+				Capacity: math.MaxInt64,
+			})
+			if err != nil {
+				return
+			}
+		}
+
+		report.Windows = append(report.Windows, m)
+	}
+
+	return
+}
+
+// liveWindows lists the windows of src alive at now, with their TTL relative to now
+func liveWindows(ctx context.Context, src fixedTruncatedWindowStorage, now time.Time) ([]snapshotWindow, error) {
+	switch s := src.(type) {
+	case snapshotSource:
+		data, err := s.Snapshot(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		snap, err := parseSnapshot(data)
+		if err != nil {
+			return nil, err
+		}
+
+		// The snapshot was just taken, so its TTLs are still accurate, even if the clock of src, e.g. a
+		// redis server, is not in sync with ours
+		var windows []snapshotWindow
+		for _, w := range snap.Windows {
+			if w.TTL > 0 {
+				windows = append(windows, w)
+			}
+		}
+
+		return windows, nil
+	case lastWindowSource:
+		window, err := s.LastWindow(ctx)
+		if err != nil {
+			if errors.Is(err, ErrNoLastKey) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		if !window.After(now) {
+			return nil, nil
+		}
+
+		counter, err := s.Get(ctx, window)
+		if err != nil {
+			return nil, err
+		}
+
+		return []snapshotWindow{{Window: window, Counter: counter, TTL: window.Sub(now)}}, nil
+	default:
+		return nil, ErrMigrationUnsupported
+	}
+}
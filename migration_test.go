@@ -0,0 +1,193 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMigrate_MemoryToRedis(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	srv := pacemakertest.NewRedis(t)
+
+	src := pacemaker.NewFixedWindowMemoryStorageWithClock(clock)
+	dst := pacemaker.NewFixedWindowRedisStorage(srv.Client, pacemaker.FixedWindowRedisStorageOpts{Prefix: "new"})
+
+	limiter := pacemaker.NewFixedWindowRateLimiter(pacemaker.FixedWindowArgs{
+		Capacity: 5,
+		Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+		Clock:    clock,
+		DB:       src,
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Try(ctx); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	report, err := pacemaker.Migrate(ctx, src, dst, pacemaker.MigrateOpts{Clock: clock})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if len(report.Windows) != 1 {
+		t.Fatalf("unexpected migrated windows, want 1, have %d", len(report.Windows))
+	}
+
+	window := report.Windows[0].Window
+
+	if want := clock.Now().Add(time.Minute); !window.Equal(want) {
+		t.Errorf("unexpected window, want %v, have %v", want, window)
+	}
+
+	if c, _ := dst.Get(ctx, window); c != 3 {
+		t.Errorf("unexpected counter, want 3, have %d", c)
+	}
+
+	if ttl := srv.TTL("new|" + strconv.FormatInt(window.UnixNano(), 10)); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("unexpected ttl, want up to %v, have %v", time.Minute, ttl)
+	}
+}
+
+func TestMigrate_RedisPrefix(t *testing.T) {
+	ctx := context.Background()
+	srv := pacemakertest.NewRedis(t)
+	window := time.Date(2022, 02, 05, 10, 24, 0, 0, time.UTC)
+
+	src := pacemaker.NewFixedWindowRedisStorage(srv.Client, pacemaker.FixedWindowRedisStorageOpts{Prefix: "old"})
+	dst := pacemaker.NewFixedWindowRedisStorage(srv.Client, pacemaker.FixedWindowRedisStorageOpts{Prefix: "new"})
+
+	_, _ = src.Inc(ctx, pacemaker.FixedWindowIncArgs{
+		Window: window, TTL: time.Minute, Tokens: 7, Capacity: 10,
+	})
+
+	tests := []struct {
+		name             string
+		dryRun           bool
+		expectedExisting int64
+		expectedCounter  int64
+	}{
+		{
+			name:             "dry run does not write",
+			dryRun:           true,
+			expectedExisting: 0,
+			expectedCounter:  0,
+		},
+		{
+			name:             "counters are copied",
+			expectedExisting: 0,
+			expectedCounter:  7,
+		},
+		{
+			name:             "counters are added to existing ones",
+			dryRun:           true,
+			expectedExisting: 7,
+			expectedCounter:  7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := pacemaker.Migrate(ctx, src, dst, pacemaker.MigrateOpts{DryRun: test.dryRun})
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			if len(report.Windows) != 1 {
+				t.Fatalf("unexpected migrated windows, want 1, have %d", len(report.Windows))
+			}
+
+			m := report.Windows[0]
+
+			if !m.Window.Equal(window) || m.Counter != 7 || m.Existing != test.expectedExisting {
+				t.Errorf("unexpected migrated window, have %+v", m)
+			}
+
+			if c, _ := dst.Get(ctx, window); c != test.expectedCounter {
+				t.Errorf("unexpected counter, want %d, have %d", test.expectedCounter, c)
+			}
+		})
+	}
+}
+
+func TestMigrate_LastWindowSource(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	mc := pacemakertest.NewMemcached(t)
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "pacemaker.db"), 0o600, nil)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+	defer db.Close()
+
+	src := pacemaker.NewFixedWindowMemcachedStorage(mc.Client, pacemaker.FixedWindowMemcachedStorageOpts{
+		Prefix: "old",
+		Clock:  clock,
+	})
+
+	dst, err := pacemaker.NewFixedWindowBoltStorage(db, pacemaker.FixedWindowBoltStorageOpts{
+		Key:   "new",
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	previous, current := clock.Now(), clock.Now().Add(10*time.Second)
+
+	for _, window := range []time.Time{previous, current} {
+		_, _ = src.Inc(ctx, pacemaker.FixedWindowIncArgs{
+			Window: window, TTL: time.Minute, Tokens: 2, Capacity: 10,
+		})
+	}
+
+	report, err := pacemaker.Migrate(ctx, src, dst, pacemaker.MigrateOpts{Clock: clock})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if len(report.Windows) != 1 || report.Windows[0].TTL != 10*time.Second {
+		t.Fatalf("expected only the current window to be migrated, have %+v", report.Windows)
+	}
+
+	if c, _ := dst.Get(ctx, current); c != 2 {
+		t.Errorf("unexpected counter, want 2, have %d", c)
+	}
+
+	clock.Forward(10 * time.Second)
+
+	if c, _ := dst.Get(ctx, current); c != 0 {
+		t.Errorf("expected migrated window to expire with its deadline, have counter %d", c)
+	}
+}
+
+// opaqueStorage cannot list its windows
+type opaqueStorage struct{}
+
+func (opaqueStorage) Inc(context.Context, pacemaker.FixedWindowIncArgs) (int64, error) {
+	return 0, nil
+}
+
+func (opaqueStorage) Get(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestMigrate_UnsupportedSource(t *testing.T) {
+	src := opaqueStorage{}
+
+	_, err := pacemaker.Migrate(context.Background(), src, pacemaker.NewFixedWindowMemoryStorage(), pacemaker.MigrateOpts{})
+
+	if !errors.Is(err, pacemaker.ErrMigrationUnsupported) {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrMigrationUnsupported, err)
+	}
+}
@@ -0,0 +1,257 @@
+package pacemakertest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+var (
+	// ErrInjected is the error returned by FaultyStorage when no other error is configured
+	ErrInjected = errors.New("pacemakertest: injected storage failure")
+)
+
+type (
+	// Sleeper is the subset of pacemaker clocks used to inject latency. Both pacemaker.RealClock and
+	// pacemaker.TestClock satisfy it, the latter allowing to inject latency in virtual time.
+	Sleeper interface {
+		NewTimer(d time.Duration) pacemaker.Timer
+	}
+
+	// FaultConfig configures which faults FaultyStorage injects and how often. Rates are probabilities in
+	// the [0, 1] range, rolled independently on every call.
+	FaultConfig struct {
+		// Seed feeds the random source deciding which calls fail. Same seed and same sequence of calls
+		// produce the same faults, so failing scenarios can be replayed.
+		Seed int64
+		// Clock is used to wait for latency and timeouts. Defaults to pacemaker.RealClock.
+		Clock Sleeper
+
+		// Latency is added to every call
+		Latency time.Duration
+		// LatencyJitter adds a random extra latency in the [0, LatencyJitter) range
+		LatencyJitter time.Duration
+
+		// ErrorRate is the probability of a call failing with Err without reaching the inner storage
+		ErrorRate float64
+		// Err is the error injected. Defaults to ErrInjected.
+		Err error
+
+		// TimeoutRate is the probability of a call hanging for Timeout, or until the context is done, and
+		// then failing with context.DeadlineExceeded
+		TimeoutRate float64
+		Timeout     time.Duration
+
+		// LostWriteRate is the probability of Inc reporting success without the increment being persisted
+		LostWriteRate float64
+		// StaleReadRate is the probability of Get returning the counter as it was before the latest
+		// increment made through this storage
+		StaleReadRate float64
+	}
+
+	// FaultStats counts the faults injected so far
+	FaultStats struct {
+		Calls       int64
+		Errors      int64
+		Timeouts    int64
+		LostWrites  int64
+		StaleReads  int64
+		Latency     time.Duration
+		Passthrough int64
+	}
+
+	// FaultyStorage decorates a storage injecting latency, errors, timeouts, lost writes and stale reads.
+	// It is meant to test how services behave when the rate limit backend misbehaves.
+	FaultyStorage struct {
+		inner Storage
+		cfg   FaultConfig
+
+		mu    sync.Mutex
+		rnd   *rand.Rand
+		stale map[int64]int64
+		stats FaultStats
+	}
+
+	operation int
+
+	faults struct {
+		latency time.Duration
+		err     bool
+		timeout bool
+		lost    bool
+		stale   bool
+	}
+)
+
+const (
+	opInc operation = iota
+	opGet
+	opLastWindow
+)
+
+// Inc increases the counter of the inner storage unless a fault is injected
+func (s *FaultyStorage) Inc(ctx context.Context, args pacemaker.FixedWindowIncArgs) (int64, error) {
+	f := s.roll(opInc)
+
+	if err := s.inject(ctx, f); err != nil {
+		return 0, err
+	}
+
+	if f.lost {
+		c, err := s.inner.Get(ctx, args.Window)
+		if err != nil {
+			return 0, err
+		}
+		return c + args.Tokens, nil
+	}
+
+	c, err := s.inner.Inc(ctx, args)
+	if err != nil {
+		return c, err
+	}
+
+	s.mu.Lock()
+	s.stale[args.Window.UnixNano()] = c - args.Tokens
+	s.mu.Unlock()
+
+	return c, nil
+}
+
+// Get returns the counter of the inner storage unless a fault is injected
+func (s *FaultyStorage) Get(ctx context.Context, window time.Time) (int64, error) {
+	f := s.roll(opGet)
+
+	if err := s.inject(ctx, f); err != nil {
+		return 0, err
+	}
+
+	if f.stale {
+		s.mu.Lock()
+		c, ok := s.stale[window.UnixNano()]
+		s.mu.Unlock()
+
+		if ok {
+			return c, nil
+		}
+	}
+
+	return s.inner.Get(ctx, window)
+}
+
+// LastWindow returns the last window of the inner storage unless a fault is injected. Returns
+// pacemaker.ErrNoLastKey if the inner storage does not implement LastWindow.
+func (s *FaultyStorage) LastWindow(ctx context.Context) (time.Time, error) {
+	f := s.roll(opLastWindow)
+
+	if err := s.inject(ctx, f); err != nil {
+		return time.Time{}, err
+	}
+
+	ls, ok := s.inner.(LastWindowStorage)
+	if !ok {
+		return time.Time{}, pacemaker.ErrNoLastKey
+	}
+
+	return ls.LastWindow(ctx)
+}
+
+// Stats returns the faults injected so far
+func (s *FaultyStorage) Stats() FaultStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+func (s *FaultyStorage) roll(op operation) (f faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Every roll is drawn on every call, so that the sequence of random numbers consumed does not depend on
+	// which faults happen to be injected
+	f.latency = s.cfg.Latency
+	jitter := s.rnd.Int63()
+	if s.cfg.LatencyJitter > 0 {
+		f.latency += time.Duration(jitter % int64(s.cfg.LatencyJitter))
+	}
+
+	f.timeout = s.rnd.Float64() < s.cfg.TimeoutRate
+	f.err = s.rnd.Float64() < s.cfg.ErrorRate
+	f.lost = s.rnd.Float64() < s.cfg.LostWriteRate && op == opInc
+	f.stale = s.rnd.Float64() < s.cfg.StaleReadRate && op == opGet
+
+	s.stats.Calls++
+	s.stats.Latency += f.latency
+
+	switch {
+	case f.timeout:
+		s.stats.Timeouts++
+	case f.err:
+		s.stats.Errors++
+	case f.lost:
+		s.stats.LostWrites++
+	case f.stale:
+		s.stats.StaleReads++
+	default:
+		s.stats.Passthrough++
+	}
+
+	return
+}
+
+func (s *FaultyStorage) inject(ctx context.Context, f faults) error {
+	if err := s.wait(ctx, f.latency); err != nil {
+		return err
+	}
+
+	if f.timeout {
+		if err := s.wait(ctx, s.cfg.Timeout); err != nil {
+			return err
+		}
+		return context.DeadlineExceeded
+	}
+
+	if f.err {
+		return s.cfg.Err
+	}
+
+	return nil
+}
+
+func (s *FaultyStorage) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := s.cfg.Clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// NewFaultyStorage returns a new instance of FaultyStorage decorating the inner storage
+func NewFaultyStorage(inner Storage, cfg FaultConfig) *FaultyStorage {
+	if cfg.Clock == nil {
+		cfg.Clock = pacemaker.NewClock()
+	}
+
+	if cfg.Err == nil {
+		cfg.Err = ErrInjected
+	}
+
+	return &FaultyStorage{
+		inner: inner,
+		cfg:   cfg,
+		rnd:   rand.New(rand.NewSource(cfg.Seed)),
+		stale: make(map[int64]int64),
+	}
+}
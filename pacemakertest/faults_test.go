@@ -0,0 +1,150 @@
+package pacemakertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+var (
+	faultsWindow = time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC)
+)
+
+func incArgs(tokens int64) pacemaker.FixedWindowIncArgs {
+	return pacemaker.FixedWindowIncArgs{
+		Window:   faultsWindow,
+		TTL:      time.Minute,
+		Tokens:   tokens,
+		Capacity: 100,
+	}
+}
+
+func TestFaultyStorage_PassthroughConformance(t *testing.T) {
+	RunStorageSuite(t, Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) Storage {
+			return NewFaultyStorage(pacemaker.NewFixedWindowMemoryStorage(), FaultConfig{Seed: 1})
+		},
+		Overflow: OverflowAccumulate,
+	})
+}
+
+func TestFaultyStorage_SameSeedSameFaults(t *testing.T) {
+	run := func(seed int64) []bool {
+		s := NewFaultyStorage(pacemaker.NewFixedWindowMemoryStorage(), FaultConfig{
+			Seed:      seed,
+			ErrorRate: 0.5,
+		})
+
+		var failures []bool
+		for i := 0; i < 64; i++ {
+			_, err := s.Inc(context.Background(), incArgs(1))
+			failures = append(failures, err != nil)
+		}
+		return failures
+	}
+
+	first, second := run(42), run(42)
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("unexpected different fault at call %d with same seed", i)
+		}
+	}
+}
+
+func TestFaultyStorage_Errors(t *testing.T) {
+	custom := errors.New("custom")
+	s := NewFaultyStorage(pacemaker.NewFixedWindowMemoryStorage(), FaultConfig{
+		ErrorRate: 1,
+		Err:       custom,
+	})
+
+	if _, err := s.Inc(context.Background(), incArgs(1)); !errors.Is(err, custom) {
+		t.Errorf("unexpected error on inc, want %v, have %v", custom, err)
+	}
+
+	if _, err := s.Get(context.Background(), faultsWindow); !errors.Is(err, custom) {
+		t.Errorf("unexpected error on get, want %v, have %v", custom, err)
+	}
+
+	if stats := s.Stats(); stats.Errors != 2 || stats.Calls != 2 {
+		t.Errorf("unexpected stats, want 2 errors out of 2 calls, have %+v", stats)
+	}
+}
+
+func TestFaultyStorage_LostWrites(t *testing.T) {
+	ctx := context.Background()
+	inner := pacemaker.NewFixedWindowMemoryStorage()
+	s := NewFaultyStorage(inner, FaultConfig{LostWriteRate: 1})
+
+	for _, expected := range []int64{2, 2} {
+		c, err := s.Inc(ctx, incArgs(2))
+		if err != nil {
+			t.Fatalf("unexpected error on inc, want none, have %v", err)
+		}
+		if c != expected {
+			t.Errorf("unexpected counter, want %d, have %d", expected, c)
+		}
+	}
+
+	if c, _ := inner.Get(ctx, faultsWindow); c != 0 {
+		t.Errorf("unexpected persisted counter, want 0, have %d", c)
+	}
+}
+
+func TestFaultyStorage_StaleReads(t *testing.T) {
+	ctx := context.Background()
+	s := NewFaultyStorage(pacemaker.NewFixedWindowMemoryStorage(), FaultConfig{StaleReadRate: 1})
+
+	_, _ = s.Inc(ctx, incArgs(2))
+	_, _ = s.Inc(ctx, incArgs(3))
+
+	if c, _ := s.Get(ctx, faultsWindow); c != 2 {
+		t.Errorf("unexpected stale counter, want 2, have %d", c)
+	}
+}
+
+func TestFaultyStorage_LatencyAndTimeouts(t *testing.T) {
+	clock := pacemaker.NewMockClock(faultsWindow)
+	s := NewFaultyStorage(pacemaker.NewFixedWindowMemoryStorage(), FaultConfig{
+		Clock:       clock,
+		Latency:     time.Second,
+		TimeoutRate: 1,
+		Timeout:     time.Second * 5,
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.Inc(context.Background(), incArgs(1))
+		errs <- err
+	}()
+
+	clock.BlockUntil(1)
+	clock.Forward(time.Second)
+	clock.BlockUntil(1)
+	clock.Forward(time.Second * 5)
+
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error, want %v, have %v", context.DeadlineExceeded, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := s.Get(ctx, faultsWindow)
+		errs <- err
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error, want %v, have %v", context.Canceled, err)
+	}
+
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("unexpected pending timers once canceled, want 0, have %d", n)
+	}
+}
@@ -0,0 +1,347 @@
+package pacemakertest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// relativeExpirationLimit is the largest expiration memcached interprets as relative seconds. Greater values are
+// unix timestamps.
+const relativeExpirationLimit = 60 * 60 * 24 * 30
+
+// Memcached is an in-process memcached server speaking the text protocol on a random localhost port. It supports
+// the storage, retrieval, cas and arithmetic commands needed by pacemaker memcached storages, so they can be tested
+// without docker. Time on the server does not pass by itself: use FastForward to expire items.
+type Memcached struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	items  map[string]memcachedItem
+	casSeq uint64
+	offset time.Duration
+	conns  map[net.Conn]struct{}
+
+	wg sync.WaitGroup
+
+	// Client is connected to the server
+	Client *memcache.Client
+}
+
+type memcachedItem struct {
+	value     []byte
+	flags     uint32
+	cas       uint64
+	expiresAt time.Time
+}
+
+// Addr returns the address the server listens at
+func (m *Memcached) Addr() string {
+	return m.ln.Addr().String()
+}
+
+// FastForward moves the server time forward, expiring items whose expiration has elapsed
+func (m *Memcached) FastForward(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.offset += d
+}
+
+// Expiration returns when the item stored at key expires, or zero time if it does not exist or never expires
+func (m *Memcached) Expiration(key string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.lookup(key)
+	if !ok {
+		return time.Time{}
+	}
+
+	return it.expiresAt
+}
+
+// Now returns the current time of the server
+func (m *Memcached) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.now()
+}
+
+// Close stops the server
+func (m *Memcached) Close() {
+	_ = m.ln.Close()
+
+	m.mu.Lock()
+	for c := range m.conns {
+		_ = c.Close()
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+func (m *Memcached) now() time.Time {
+	return time.Now().Add(m.offset)
+}
+
+// lookup must be called with the lock held
+func (m *Memcached) lookup(key string) (memcachedItem, bool) {
+	it, ok := m.items[key]
+	if !ok {
+		return it, false
+	}
+
+	if !it.expiresAt.IsZero() && !it.expiresAt.After(m.now()) {
+		delete(m.items, key)
+		return it, false
+	}
+
+	return it, true
+}
+
+// store must be called with the lock held
+func (m *Memcached) store(key string, value []byte, flags uint32, exptime int64) {
+	m.casSeq++
+
+	it := memcachedItem{value: value, flags: flags, cas: m.casSeq}
+
+	switch {
+	case exptime < 0:
+		delete(m.items, key)
+		return
+	case exptime == 0:
+	case exptime <= relativeExpirationLimit:
+		it.expiresAt = m.now().Add(time.Duration(exptime) * time.Second)
+	default:
+		it.expiresAt = time.Unix(exptime, 0)
+	}
+
+	m.items[key] = it
+}
+
+func (m *Memcached) serve() {
+	defer m.wg.Done()
+
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		m.mu.Lock()
+		m.conns[conn] = struct{}{}
+		m.mu.Unlock()
+
+		m.wg.Add(1)
+		go m.handle(conn)
+	}
+}
+
+func (m *Memcached) handle(conn net.Conn) {
+	defer m.wg.Done()
+	defer func() {
+		m.mu.Lock()
+		delete(m.conns, conn)
+		m.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := m.exec(rw, fields); err != nil {
+			return
+		}
+
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (m *Memcached) exec(rw *bufio.ReadWriter, fields []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "get", "gets":
+		for _, key := range args {
+			it, ok := m.lookup(key)
+			if !ok {
+				continue
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(rw, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.value), it.cas)
+			} else {
+				fmt.Fprintf(rw, "VALUE %s %d %d\r\n", key, it.flags, len(it.value))
+			}
+			rw.Write(it.value)
+			rw.WriteString("\r\n")
+		}
+		rw.WriteString("END\r\n")
+	case "set", "add", "replace", "cas":
+		return m.execStorage(rw, cmd, args)
+	case "incr", "decr":
+		if len(args) < 2 {
+			rw.WriteString("ERROR\r\n")
+			return nil
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			rw.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+			return nil
+		}
+		it, ok := m.lookup(args[0])
+		if !ok {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		n, err := strconv.ParseUint(string(it.value), 10, 64)
+		if err != nil {
+			rw.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+			return nil
+		}
+		if cmd == "incr" {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		m.casSeq++
+		it.value = []byte(strconv.FormatUint(n, 10))
+		it.cas = m.casSeq
+		m.items[args[0]] = it
+		fmt.Fprintf(rw, "%d\r\n", n)
+	case "touch":
+		if len(args) < 2 {
+			rw.WriteString("ERROR\r\n")
+			return nil
+		}
+		exptime, _ := strconv.ParseInt(args[1], 10, 64)
+		it, ok := m.lookup(args[0])
+		if !ok {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		m.store(args[0], it.value, it.flags, exptime)
+		rw.WriteString("TOUCHED\r\n")
+	case "delete":
+		if len(args) < 1 {
+			rw.WriteString("ERROR\r\n")
+			return nil
+		}
+		if _, ok := m.lookup(args[0]); !ok {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		delete(m.items, args[0])
+		rw.WriteString("DELETED\r\n")
+	case "flush_all":
+		m.items = make(map[string]memcachedItem)
+		rw.WriteString("OK\r\n")
+	case "version":
+		rw.WriteString("VERSION pacemakertest\r\n")
+	default:
+		rw.WriteString("ERROR\r\n")
+	}
+
+	return nil
+}
+
+func (m *Memcached) execStorage(rw *bufio.ReadWriter, cmd string, args []string) error {
+	if len(args) < 4 || (cmd == "cas" && len(args) < 5) {
+		rw.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	flags, _ := strconv.ParseUint(args[1], 10, 32)
+	exptime, _ := strconv.ParseInt(args[2], 10, 64)
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		rw.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return err
+	}
+	value := data[:size]
+
+	key := args[0]
+	it, exists := m.lookup(key)
+
+	switch cmd {
+	case "add":
+		if exists {
+			rw.WriteString("NOT_STORED\r\n")
+			return nil
+		}
+	case "replace":
+		if !exists {
+			rw.WriteString("NOT_STORED\r\n")
+			return nil
+		}
+	case "cas":
+		cas, _ := strconv.ParseUint(args[4], 10, 64)
+		if !exists {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		if it.cas != cas {
+			rw.WriteString("EXISTS\r\n")
+			return nil
+		}
+	}
+
+	m.store(key, value, uint32(flags), exptime)
+	rw.WriteString("STORED\r\n")
+
+	return nil
+}
+
+// NewMemcached starts a new in-process memcached server. It is stopped when the test finishes.
+func NewMemcached(t testing.TB) *Memcached {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("pacemakertest: cannot start memcached: %v", err)
+	}
+
+	m := &Memcached{
+		ln:    ln,
+		items: make(map[string]memcachedItem),
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	m.Client = memcache.New(m.Addr())
+
+	m.wg.Add(1)
+	go m.serve()
+
+	t.Cleanup(m.Close)
+
+	return m
+}
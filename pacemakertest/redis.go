@@ -0,0 +1,70 @@
+package pacemakertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+)
+
+// Redis is an in-process redis server speaking RESP on a random localhost port. It supports the commands and
+// the LUA scripting needed by pacemaker redis storages, so they can be tested without docker. Time on the server
+// does not pass by itself: use FastForward to expire keys.
+type Redis struct {
+	srv *miniredis.Miniredis
+
+	// Client is connected to the server and closed along with it
+	Client *redis.Client
+}
+
+// Addr returns the address the server listens at
+func (r *Redis) Addr() string {
+	return r.srv.Addr()
+}
+
+// FastForward moves the server time forward, expiring keys whose TTL has elapsed
+func (r *Redis) FastForward(d time.Duration) {
+	r.srv.FastForward(d)
+}
+
+// FlushScripts removes all cached LUA scripts, so the next EVALSHA fails with NOSCRIPT
+func (r *Redis) FlushScripts(ctx context.Context) error {
+	return r.Client.ScriptFlush(ctx).Err()
+}
+
+// TTL returns the time to live of key, or zero if it does not exist or has no expiration
+func (r *Redis) TTL(key string) time.Duration {
+	return r.srv.TTL(key)
+}
+
+// Keys returns all keys stored, sorted
+func (r *Redis) Keys() []string {
+	return r.srv.Keys()
+}
+
+// Close stops the server and closes the client
+func (r *Redis) Close() {
+	_ = r.Client.Close()
+	r.srv.Close()
+}
+
+// NewRedis starts a new in-process redis server. It is stopped when the test finishes.
+func NewRedis(t testing.TB) *Redis {
+	t.Helper()
+
+	srv := miniredis.NewMiniRedis()
+	if err := srv.Start(); err != nil {
+		t.Fatalf("pacemakertest: cannot start redis: %v", err)
+	}
+
+	r := &Redis{
+		srv:    srv,
+		Client: redis.NewClient(&redis.Options{Addr: srv.Addr()}),
+	}
+
+	t.Cleanup(r.Close)
+
+	return r
+}
@@ -0,0 +1,363 @@
+// Package pacemakertest provides utilities to test pacemaker storages, ours or third-party ones. Storages are
+// exercised through the same methods fixed window rate limiters call, driven by a controllable clock.
+package pacemakertest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+type (
+	// Storage is the set of methods every fixed window storage implements. Storages that also
+	// implement LastWindowStorage are checked against LastWindow semantics as well.
+	Storage interface {
+		Inc(ctx context.Context, args pacemaker.FixedWindowIncArgs) (int64, error)
+		Get(ctx context.Context, window time.Time) (int64, error)
+	}
+
+	// LastWindowStorage is implemented by storages backing FixedWindowRateLimiter
+	LastWindowStorage interface {
+		Storage
+		LastWindow(ctx context.Context) (time.Time, error)
+	}
+
+	// OverflowPolicy describes what a storage keeps after an Inc that does not fit in the capacity
+	OverflowPolicy int
+
+	// Harness describes how to build and drive the storage under test
+	Harness struct {
+		// New returns an empty storage. It is called once per subtest. The clock given is the one the suite
+		// moves forward, so storages that read time on their own should be built on top of it.
+		New func(t *testing.T, clock *pacemaker.TestClock) Storage
+		// Advance, if set, is called every time the suite moves its clock forward. Storages whose notion of
+		// time cannot be bound to a pacemaker clock (e.g. a fake redis server) can follow along from here.
+		Advance func(d time.Duration)
+		// Expires tells whether the storage drops windows once their TTL has elapsed. When false, TTL
+		// related tests are skipped.
+		Expires bool
+		// Overflow is the policy the storage applies to increments exceeding the capacity
+		Overflow OverflowPolicy
+		// Start is the moment the suite clock starts at. Defaults to 2022-02-05 10:23:23 UTC.
+		Start time.Time
+	}
+)
+
+const (
+	// OverflowReject storages do not apply increments exceeding the capacity. E.g: FixedWindowRedisStorage
+	OverflowReject OverflowPolicy = iota
+	// OverflowClamp storages cap the counter to the capacity. E.g: FixedTruncatedWindowMemoryStorage
+	OverflowClamp
+	// OverflowAccumulate storages apply every increment, regardless of capacity. E.g: FixedWindowMemoryStorage
+	OverflowAccumulate
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowReject:
+		return "reject"
+	case OverflowClamp:
+		return "clamp"
+	case OverflowAccumulate:
+		return "accumulate"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	unbounded = math.MaxInt64 / 2
+)
+
+// RunStorageSuite runs the conformance test suite against the storage produced by the harness. Each
+// test case runs as a subtest with a fresh storage.
+func RunStorageSuite(t *testing.T, h Harness) {
+	t.Helper()
+
+	if h.New == nil {
+		t.Fatal("pacemakertest: harness has no storage factory")
+	}
+
+	if h.Start.IsZero() {
+		h.Start = time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *suite)
+	}{
+		{name: "get on empty window returns zero", run: testGetEmpty},
+		{name: "inc accumulates tokens", run: testIncAccumulates},
+		{name: "get does not increase the counter", run: testGetIsReadOnly},
+		{name: "inc over capacity reports the overflowing counter", run: testIncOverCapacity},
+		{name: "overflow policy is honored", run: testOverflowPolicy},
+		{name: "windows are independent", run: testWindowRollover},
+		{name: "last window on empty storage", run: testLastWindowEmpty},
+		{name: "last window follows the latest window", run: testLastWindowFollowsLatest},
+		{name: "windows expire after ttl", run: testTTL},
+		{name: "concurrent increments are not lost", run: testConcurrentInc},
+		{name: "canceled context is reported", run: testContextCanceled},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			clock := pacemaker.NewMockClock(h.Start)
+			test.run(t, &suite{
+				h:       h,
+				clock:   clock,
+				storage: h.New(t, clock),
+			})
+		})
+	}
+}
+
+type suite struct {
+	h       Harness
+	clock   *pacemaker.TestClock
+	storage Storage
+}
+
+func (s *suite) forward(d time.Duration) {
+	s.clock.Forward(d)
+	if s.h.Advance != nil {
+		s.h.Advance(d)
+	}
+}
+
+func (s *suite) inc(t *testing.T, window time.Time, tokens, capacity int64) int64 {
+	t.Helper()
+
+	c, err := s.storage.Inc(context.Background(), pacemaker.FixedWindowIncArgs{
+		Window:   window,
+		TTL:      time.Minute,
+		Tokens:   tokens,
+		Capacity: capacity,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on inc, want none, have %v", err)
+	}
+	return c
+}
+
+func (s *suite) get(t *testing.T, window time.Time) int64 {
+	t.Helper()
+
+	c, err := s.storage.Get(context.Background(), window)
+	if err != nil {
+		t.Fatalf("unexpected error on get, want none, have %v", err)
+	}
+	return c
+}
+
+func (s *suite) window() time.Time {
+	return s.clock.Now().Truncate(time.Minute)
+}
+
+func assertCounter(t *testing.T, what string, expected, actual int64) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("unexpected counter on %s, want %d, have %d", what, expected, actual)
+	}
+}
+
+func testGetEmpty(t *testing.T, s *suite) {
+	assertCounter(t, "get", 0, s.get(t, s.window()))
+}
+
+func testIncAccumulates(t *testing.T, s *suite) {
+	w := s.window()
+
+	assertCounter(t, "inc", 1, s.inc(t, w, 1, 10))
+	assertCounter(t, "inc", 4, s.inc(t, w, 3, 10))
+	assertCounter(t, "inc", 10, s.inc(t, w, 6, 10))
+	assertCounter(t, "get", 10, s.get(t, w))
+}
+
+func testGetIsReadOnly(t *testing.T, s *suite) {
+	w := s.window()
+
+	s.inc(t, w, 2, 10)
+
+	for i := 0; i < 3; i++ {
+		assertCounter(t, "get", 2, s.get(t, w))
+	}
+}
+
+func testIncOverCapacity(t *testing.T, s *suite) {
+	w := s.window()
+
+	assertCounter(t, "inc", 3, s.inc(t, w, 3, 5))
+	assertCounter(t, "inc", 6, s.inc(t, w, 3, 5))
+}
+
+func testOverflowPolicy(t *testing.T, s *suite) {
+	w := s.window()
+
+	s.inc(t, w, 3, 5)
+	s.inc(t, w, 3, 5)
+
+	switch s.h.Overflow {
+	case OverflowReject:
+		assertCounter(t, "get after rejected inc", 3, s.get(t, w))
+		assertCounter(t, "inc fitting after rejected inc", 5, s.inc(t, w, 2, 5))
+	case OverflowClamp:
+		assertCounter(t, "get after clamped inc", 5, s.get(t, w))
+	case OverflowAccumulate:
+		assertCounter(t, "get after overflowing inc", 6, s.get(t, w))
+	default:
+		t.Fatalf("unknown overflow policy %d", s.h.Overflow)
+	}
+}
+
+func testWindowRollover(t *testing.T, s *suite) {
+	first := s.window()
+
+	s.inc(t, first, 4, 10)
+
+	s.forward(time.Minute)
+	second := s.window()
+
+	assertCounter(t, "get on new window", 0, s.get(t, second))
+	assertCounter(t, "inc on new window", 1, s.inc(t, second, 1, 10))
+	assertCounter(t, "get on new window", 1, s.get(t, second))
+}
+
+func testLastWindowEmpty(t *testing.T, s *suite) {
+	ls, ok := s.storage.(LastWindowStorage)
+	if !ok {
+		t.Skip("storage does not implement LastWindow")
+	}
+
+	ts, err := ls.LastWindow(context.Background())
+
+	if err != nil {
+		if !errors.Is(err, pacemaker.ErrNoLastKey) {
+			t.Errorf("unexpected error on last window, want %v, have %v", pacemaker.ErrNoLastKey, err)
+		}
+		return
+	}
+
+	if !ts.IsZero() {
+		t.Errorf("unexpected last window on empty storage, want zero time, have %v", ts)
+	}
+}
+
+func testLastWindowFollowsLatest(t *testing.T, s *suite) {
+	ls, ok := s.storage.(LastWindowStorage)
+	if !ok {
+		t.Skip("storage does not implement LastWindow")
+	}
+
+	assertLastWindow := func(expected time.Time) {
+		t.Helper()
+
+		actual, err := ls.LastWindow(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error on last window, want none, have %v", err)
+		}
+
+		if !actual.Equal(expected) {
+			t.Errorf("unexpected last window, want %v, have %v", expected, actual)
+		}
+	}
+
+	first := s.window()
+	s.inc(t, first, 1, 10)
+	assertLastWindow(first)
+
+	s.forward(time.Minute)
+	second := s.window()
+	s.inc(t, second, 1, 10)
+	assertLastWindow(second)
+}
+
+func testTTL(t *testing.T, s *suite) {
+	if !s.h.Expires {
+		t.Skip("storage does not expire windows")
+	}
+
+	w := s.window()
+
+	_, err := s.storage.Inc(context.Background(), pacemaker.FixedWindowIncArgs{
+		Window:   w,
+		TTL:      10 * time.Second,
+		Tokens:   3,
+		Capacity: 10,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on inc, want none, have %v", err)
+	}
+
+	s.forward(9 * time.Second)
+	assertCounter(t, "get before ttl", 3, s.get(t, w))
+
+	s.forward(2 * time.Second)
+	assertCounter(t, "get after ttl", 0, s.get(t, w))
+}
+
+func testConcurrentInc(t *testing.T, s *suite) {
+	const (
+		workers = 16
+		times   = 50
+	)
+
+	w := s.window()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*times)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				_, err := s.storage.Inc(context.Background(), pacemaker.FixedWindowIncArgs{
+					Window:   w,
+					TTL:      time.Minute,
+					Tokens:   1,
+					Capacity: unbounded,
+				})
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("unexpected error on concurrent inc, want none, have %v", err)
+	}
+
+	assertCounter(t, "get after concurrent inc", workers*times, s.get(t, w))
+}
+
+func testContextCanceled(t *testing.T, s *suite) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.storage.Inc(ctx, pacemaker.FixedWindowIncArgs{
+		Window:   s.window(),
+		TTL:      time.Minute,
+		Tokens:   1,
+		Capacity: 10,
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error on inc, want %v, have %v", context.Canceled, err)
+	}
+
+	_, err = s.storage.Get(ctx, s.window())
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error on get, want %v, have %v", context.Canceled, err)
+	}
+}
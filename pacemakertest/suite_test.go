@@ -0,0 +1,25 @@
+package pacemakertest
+
+import (
+	"testing"
+
+	"github.com/sonirico/pacemaker"
+)
+
+func TestFixedWindowMemoryStorage(t *testing.T) {
+	RunStorageSuite(t, Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) Storage {
+			return pacemaker.NewFixedWindowMemoryStorage()
+		},
+		Overflow: OverflowAccumulate,
+	})
+}
+
+func TestFixedTruncatedWindowMemoryStorage(t *testing.T) {
+	RunStorageSuite(t, Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) Storage {
+			return pacemaker.NewFixedTruncatedWindowMemoryStorage()
+		},
+		Overflow: OverflowClamp,
+	})
+}
@@ -0,0 +1,94 @@
+package pacemaker
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// penaltyStorage is implemented by storages sharing penalties among every limiter using them, such as
+	// FixedWindowRedisStorage
+	penaltyStorage interface {
+		// Penalize keeps the penalty for ttl, the time left until it ends
+		Penalize(ctx context.Context, until time.Time, ttl time.Duration) error
+		// Penalty returns the time the current penalty ends at, zero if there is none
+		Penalty(ctx context.Context) (time.Time, error)
+	}
+
+	// penalizer is a rate limiter able to be blocked until a given time, such as FixedWindowRateLimiter
+	penalizer interface {
+		Penalize(ctx context.Context, until time.Time) error
+	}
+
+	// penaltyBox holds the penalty of a limiter, shared through its storage if it supports it
+	penaltyBox struct {
+		until time.Time
+		// readAt is the last time the shared penalty was read from the storage
+		readAt time.Time
+	}
+)
+
+// penaltyReadInterval bounds how often the shared penalty is read from the storage, sparing a round trip per
+// call. Penalties set by other processes are thus noticed up to this late.
+const penaltyReadInterval = time.Second
+
+// penalize blocks until the given time, unless blocked for longer already
+func (p *penaltyBox) penalize(ctx context.Context, db any, until, now time.Time) error {
+	if !until.After(now) {
+		return nil
+	}
+
+	if until.After(p.until) {
+		p.until = until
+	}
+
+	if s, ok := db.(penaltyStorage); ok {
+		return s.Penalize(ctx, until, until.Sub(now))
+	}
+
+	return nil
+}
+
+// wait returns how long is left of the penalty as of now, zero if there is none. The shared penalty is read at
+// most once per penaltyReadInterval.
+func (p *penaltyBox) wait(ctx context.Context, db any, now time.Time) (time.Duration, error) {
+	if s, ok := db.(penaltyStorage); ok && !now.Before(p.readAt.Add(penaltyReadInterval)) {
+		shared, err := s.Penalty(ctx)
+		if err != nil {
+			return 0, err
+		}
+		p.readAt = now
+
+		if shared.After(p.until) {
+			p.until = shared
+		}
+	}
+
+	if p.until.After(now) {
+		return p.until.Sub(now), nil
+	}
+
+	return 0, nil
+}
+
+// retryAfter returns the time the server asks to wait until, as of now, from the Retry-After header of resp,
+// either in seconds or as an HTTP date
+func retryAfter(resp *http.Response, now time.Time) (time.Time, bool) {
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), seconds > 0
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return at, at.After(now)
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,217 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestLimiters_Penalize(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		newLimiters   func(clock *pacemaker.TestClock) (penalized, other penalizedLimiter)
+		expectedOther bool
+	}{
+		{
+			name: "memory storage penalizes the limiter alone",
+			newLimiters: func(clock *pacemaker.TestClock) (penalizedLimiter, penalizedLimiter) {
+				return newTruncatedLimiter(clock, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)),
+					newTruncatedLimiter(clock, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock))
+			},
+			expectedOther: false,
+		},
+		{
+			name: "redis storage penalizes every limiter sharing its prefix",
+			newLimiters: func(clock *pacemaker.TestClock) (penalizedLimiter, penalizedLimiter) {
+				cli := pacemakertest.NewRedis(t).Client
+				opts := pacemaker.FixedWindowRedisStorageOpts{Prefix: "exchange"}
+
+				return newTruncatedLimiter(clock, pacemaker.NewFixedWindowRedisStorage(cli, opts)),
+					newTruncatedLimiter(clock, pacemaker.NewFixedWindowRedisStorage(cli, opts))
+			},
+			expectedOther: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+			penalized, other := test.newLimiters(clock)
+
+			if err := penalized.Penalize(ctx, clock.Now().Add(90*time.Second)); err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			clock.Forward(30 * time.Second)
+
+			r, err := penalized.Try(ctx, 1)
+			if !errors.Is(err, pacemaker.ErrRateLimitExceeded) || r.TimeToWait != time.Minute {
+				t.Errorf("unexpected result, want %v after %v, have %v after %v",
+					pacemaker.ErrRateLimitExceeded, time.Minute, err, r.TimeToWait)
+			}
+
+			_, err = other.Try(ctx, 1)
+			if blocked := errors.Is(err, pacemaker.ErrRateLimitExceeded); blocked != test.expectedOther {
+				t.Errorf("unexpected penalty of other limiter, want %v, have %v", test.expectedOther, blocked)
+			}
+
+			// Shorter penalties do not shorten the current one
+			_ = penalized.Penalize(ctx, clock.Now().Add(time.Second))
+
+			clock.Forward(59 * time.Second)
+
+			if _, err := penalized.Try(ctx, 1); !errors.Is(err, pacemaker.ErrRateLimitExceeded) {
+				t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrRateLimitExceeded, err)
+			}
+
+			clock.Forward(time.Second)
+
+			if _, err := penalized.Try(ctx, 1); err != nil {
+				t.Errorf("unexpected error once the penalty is over, want none, have %v", err)
+			}
+		})
+	}
+}
+
+type penalizedLimiter interface {
+	Try(ctx context.Context, tokens int64) (pacemaker.Result, error)
+	Penalize(ctx context.Context, until time.Time) error
+}
+
+func newTruncatedLimiter(clock *pacemaker.TestClock, db pacemakertest.Storage) *pacemaker.TokenFixedWindowRateLimiter {
+	l := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+			Capacity: 10,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       db,
+		}),
+	)
+	return &l
+}
+
+func TestTransport_Penalize(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	var served int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&served, 1) == 1 {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	limiter := newTruncatedLimiter(clock, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock))
+
+	cli := &http.Client{Transport: pacemaker.NewTransport(pacemaker.TransportOpts{
+		Limiter:  limiter,
+		Penalize: true,
+		Clock:    clock,
+	})}
+
+	get := func() error {
+		resp, err := cli.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- get() }()
+
+	// The request waits for the penalty to be over
+	clock.BlockUntil(1)
+	clock.Forward(29 * time.Second)
+	clock.BlockUntil(1)
+
+	if n := atomic.LoadInt64(&served); n != 1 {
+		t.Fatalf("unexpected served requests while penalized, want 1, have %d", n)
+	}
+
+	clock.Forward(time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if n := atomic.LoadInt64(&served); n != 2 {
+		t.Errorf("unexpected served requests, want 2, have %d", n)
+	}
+}
+
+// roundTrips counts the commands a redis client sends
+type roundTrips struct {
+	n int64
+}
+
+func (h *roundTrips) BeforeProcess(ctx context.Context, _ goredis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.n, 1)
+	return ctx, nil
+}
+
+func (h *roundTrips) AfterProcess(context.Context, goredis.Cmder) error {
+	return nil
+}
+
+func (h *roundTrips) BeforeProcessPipeline(ctx context.Context, _ []goredis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.n, 1)
+	return ctx, nil
+}
+
+func (h *roundTrips) AfterProcessPipeline(context.Context, []goredis.Cmder) error {
+	return nil
+}
+
+func TestLimiters_PenaltyReads(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	cli := pacemakertest.NewRedis(t).Client
+	opts := pacemaker.FixedWindowRedisStorageOpts{Prefix: "exchange"}
+
+	trips := &roundTrips{}
+	cli.AddHook(trips)
+
+	limiter := newTruncatedLimiter(clock, pacemaker.NewFixedWindowRedisStorage(cli, opts))
+	other := newTruncatedLimiter(clock, pacemaker.NewFixedWindowRedisStorage(cli, opts))
+
+	if _, err := limiter.Try(ctx, 1); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	// Calls within the read interval spare reading the shared penalty
+	before := atomic.LoadInt64(&trips.n)
+	for i := 0; i < 3; i++ {
+		_, _ = limiter.Try(ctx, 1)
+	}
+
+	if n := atomic.LoadInt64(&trips.n) - before; n != 3 {
+		t.Errorf("unexpected round trips, want 3, have %d", n)
+	}
+
+	if err := other.Penalize(ctx, clock.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	clock.Forward(time.Second)
+
+	if _, err := limiter.Try(ctx, 1); !errors.Is(err, pacemaker.ErrRateLimitExceeded) {
+		t.Errorf("unexpected error once the penalty is read, want %v, have %v", pacemaker.ErrRateLimitExceeded, err)
+	}
+}
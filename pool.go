@@ -0,0 +1,130 @@
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+type (
+	// poolLimiter is the rate limiter of a pool member, such as TokenFixedWindowRateLimiter or ExchangeLimiter
+	poolLimiter interface {
+		Try(ctx context.Context, tokens int64) (Result, error)
+		Check(ctx context.Context, tokens int64) (Result, error)
+	}
+
+	// PoolMember is a member of a pool, such as an API key or an egress IP, along the rate limiter of its limits
+	PoolMember[T any] struct {
+		Name    string
+		Value   T
+		Limiter poolLimiter
+	}
+
+	// Reservation is the member a pool acquired tokens from, along the result of consuming them
+	Reservation[T any] struct {
+		Member PoolMember[T]
+		Result Result
+	}
+
+	// Pool spreads calls across several members, each one with its own rate limits
+	Pool[T any] struct {
+		members []PoolMember[T]
+	}
+
+	// poolCandidate is the state of a member checked by Acquire
+	poolCandidate struct {
+		index int
+		res   Result
+		fits  bool
+	}
+)
+
+// Acquire consumes cost tokens from the member with the most free slots among the ones the cost fits in, trying
+// the next ones should its rate limit be exceeded meanwhile. When it fits in none, it returns the reservation of
+// the member available the soonest, with the time to wait, and ErrRateLimitExceeded. Members whose limiter fails
+// are skipped, their error being returned if no member is left.
+func (p *Pool[T]) Acquire(ctx context.Context, cost int64) (Reservation[T], error) {
+	if len(p.members) == 0 {
+		return Reservation[T]{}, ErrEmptyPool
+	}
+
+	var (
+		candidates = make([]poolCandidate, 0, len(p.members))
+		lastErr    error
+	)
+
+	for i, m := range p.members {
+		r, err := m.Limiter.Check(ctx, cost)
+		if err != nil && !errors.Is(err, ErrRateLimitExceeded) {
+			lastErr = err
+			continue
+		}
+
+		candidates = append(candidates, poolCandidate{index: i, res: r, fits: err == nil})
+	}
+
+	if len(candidates) == 0 {
+		return Reservation[T]{}, lastErr
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.fits != b.fits {
+			return a.fits
+		}
+		if a.fits {
+			return a.res.FreeSlots > b.res.FreeSlots
+		}
+		return a.res.TimeToWait < b.res.TimeToWait
+	})
+
+	var soonest *Reservation[T]
+
+	// consider keeps the member available the soonest, should the cost fit in none
+	consider := func(m PoolMember[T], r Result) {
+		if soonest == nil || r.TimeToWait < soonest.Result.TimeToWait {
+			soonest = &Reservation[T]{Member: m, Result: r}
+		}
+	}
+
+	for _, c := range candidates {
+		m := p.members[c.index]
+
+		if !c.fits {
+			consider(m, c.res)
+			continue
+		}
+
+		r, err := m.Limiter.Try(ctx, cost)
+		if err == nil {
+			return Reservation[T]{Member: m, Result: r}, nil
+		}
+
+		if !errors.Is(err, ErrRateLimitExceeded) {
+			lastErr = err
+			continue
+		}
+
+		consider(m, r)
+	}
+
+	if soonest == nil {
+		return Reservation[T]{}, lastErr
+	}
+
+	return *soonest, ErrRateLimitExceeded
+}
+
+// Members returns a copy of the members of the pool, in the order they were given
+func (p *Pool[T]) Members() []PoolMember[T] {
+	members := make([]PoolMember[T], len(p.members))
+	copy(members, p.members)
+	return members
+}
+
+// NewPool returns a new instance of Pool holding a copy of members
+func NewPool[T any](members ...PoolMember[T]) *Pool[T] {
+	p := &Pool[T]{members: make([]PoolMember[T], len(members))}
+	copy(p.members, members)
+	return p
+}
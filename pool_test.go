@@ -0,0 +1,155 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func newPoolLimiter(
+	clock *pacemaker.TestClock,
+	capacity int64,
+	rate pacemaker.Rate,
+	db pacemakertest.Storage,
+) *pacemaker.TokenFixedWindowRateLimiter {
+	l := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+			Capacity: capacity,
+			Rate:     rate,
+			Clock:    clock,
+			DB:       db,
+		}),
+	)
+	return &l
+}
+
+func TestPool_Acquire(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 20, 0, time.UTC))
+
+	perMinute := pacemaker.Rate{Amount: 1, Unit: time.Minute}
+	per10s := pacemaker.Rate{Amount: 1, Unit: 10 * time.Second}
+
+	pool := pacemaker.NewPool(
+		pacemaker.PoolMember[string]{
+			Name:    "alice",
+			Value:   "alice-key",
+			Limiter: newPoolLimiter(clock, 4, perMinute, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)),
+		},
+		pacemaker.PoolMember[string]{
+			Name:    "bob",
+			Value:   "bob-key",
+			Limiter: newPoolLimiter(clock, 6, per10s, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)),
+		},
+	)
+
+	steps := []struct {
+		name           string
+		cost           int64
+		expectedMember string
+		expectedRes    pacemaker.Result
+		expectedErr    error
+	}{
+		{
+			name:           "most free slots",
+			cost:           3,
+			expectedMember: "bob",
+			expectedRes:    pacemaker.Result{FreeSlots: 3},
+		},
+		{
+			name:           "ties go to the first member",
+			cost:           1,
+			expectedMember: "alice",
+			expectedRes:    pacemaker.Result{FreeSlots: 3},
+		},
+		{
+			name:           "the only member the cost fits in",
+			cost:           3,
+			expectedMember: "alice",
+			expectedRes:    pacemaker.Result{FreeSlots: 0},
+		},
+		{
+			name:           "fits in no member, soonest available",
+			cost:           4,
+			expectedMember: "bob",
+			expectedRes:    pacemaker.Result{TimeToWait: 10 * time.Second, FreeSlots: 3},
+			expectedErr:    pacemaker.ErrRateLimitExceeded,
+		},
+	}
+
+	for _, step := range steps {
+		r, err := pool.Acquire(ctx, step.cost)
+
+		if !errors.Is(err, step.expectedErr) {
+			t.Errorf("%s: unexpected error, want %v, have %v", step.name, step.expectedErr, err)
+		}
+
+		if r.Member.Name != step.expectedMember {
+			t.Errorf("%s: unexpected member, want %s, have %s", step.name, step.expectedMember, r.Member.Name)
+		}
+
+		if r.Result != step.expectedRes {
+			t.Errorf("%s: unexpected result, want %+v, have %+v", step.name, step.expectedRes, r.Result)
+		}
+	}
+
+	if r, _ := pool.Acquire(ctx, 1); r.Member.Value != "bob-key" {
+		t.Errorf("unexpected member value, want bob-key, have %s", r.Member.Value)
+	}
+}
+
+func TestPool_Acquire_Errors(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 20, 0, time.UTC))
+	rate := pacemaker.Rate{Amount: 1, Unit: time.Minute}
+
+	faulty := func() pacemakertest.Storage {
+		inner := pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)
+		return pacemakertest.NewFaultyStorage(inner, pacemakertest.FaultConfig{ErrorRate: 1})
+	}
+
+	pool := pacemaker.NewPool(
+		pacemaker.PoolMember[int]{Name: "down", Limiter: newPoolLimiter(clock, 10, rate, faulty())},
+		pacemaker.PoolMember[int]{
+			Name:    "up",
+			Limiter: newPoolLimiter(clock, 1, rate, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)),
+		},
+	)
+
+	if r, err := pool.Acquire(ctx, 1); err != nil || r.Member.Name != "up" {
+		t.Errorf("unexpected reservation, want up, have %s, error %v", r.Member.Name, err)
+	}
+
+	if _, err := pacemaker.NewPool(pool.Members()[0]).Acquire(ctx, 1); !errors.Is(err, pacemakertest.ErrInjected) {
+		t.Errorf("unexpected error, want %v, have %v", pacemakertest.ErrInjected, err)
+	}
+
+	if _, err := pacemaker.NewPool[int]().Acquire(ctx, 1); !errors.Is(err, pacemaker.ErrEmptyPool) {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrEmptyPool, err)
+	}
+}
+
+func TestPool_Members(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 20, 0, time.UTC))
+	rate := pacemaker.Rate{Amount: 1, Unit: time.Minute}
+
+	members := []pacemaker.PoolMember[int]{{
+		Name:    "alice",
+		Limiter: newPoolLimiter(clock, 1, rate, pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)),
+	}}
+
+	pool := pacemaker.NewPool(members...)
+
+	// The pool holds its own members, whatever callers do with theirs
+	members[0].Name = "mallory"
+	pool.Members()[0].Name = "mallory"
+
+	if r, err := pool.Acquire(ctx, 1); err != nil || r.Member.Name != "alice" {
+		t.Errorf("unexpected reservation, want alice, have %s, error %v", r.Member.Name, err)
+	}
+}
@@ -0,0 +1,451 @@
+package pacemaker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	RESPServerArgs struct {
+		// Storage holds the counters of every throttled key. Defaults to a KeyedMemoryStorage.
+		Storage keyedStorage
+		// MaxKeys bounds how many rate limiters are kept, see KeyedRateLimiterArgs
+		MaxKeys int
+		// Clock defaults to RealClock
+		Clock clock
+	}
+
+	// RESPServer speaks the redis protocol, answering the CL.THROTTLE command of redis-cell
+	// (https://github.com/brandur/redis-cell), so that redis clients in any language can use pacemaker:
+	//
+	//	CL.THROTTLE <key> <max_burst> <count per period> <period> [<quantity>]
+	//
+	// It replies the same five integers:
+	//
+	//	1. whether the action is limited, 0 or 1
+	//	2. the total limit of the key, max_burst + 1
+	//	3. the remaining limit of the key
+	//	4. the seconds until the action should be retried, -1 if it is allowed
+	//	5. the seconds until the limit of the key resets to its maximum
+	//
+	// Unlike redis-cell, which implements GCRA, limits are enforced with fixed truncated windows. A window lets
+	// max_burst + 1 actions through and lasts as long as it takes to replenish them at the given rate, i.e.
+	// period × (max_burst + 1) / count. Therefore, long-term rates are the same, but limits are replenished at
+	// once, on window boundaries, instead of one by one. A quantity of 0 queries the limit without consuming it.
+	//
+	// PING, ECHO, COMMAND and QUIT are answered as well, for clients to check their connections.
+	RESPServer struct {
+		args     RESPServerArgs
+		limiters *KeyedRateLimiter
+
+		mu        sync.Mutex
+		closed    bool
+		listeners map[net.Listener]struct{}
+		conns     map[net.Conn]struct{}
+		wg        sync.WaitGroup
+	}
+
+	// cellLimit is the limit CL.THROTTLE applies to a key
+	cellLimit struct {
+		burst  int64
+		count  int64
+		period int64
+	}
+
+	respError string
+)
+
+const (
+	respMaxArgs    = 1024
+	respMaxBulkLen = 64 * 1024
+)
+
+var errRESPProtocol = errors.New("protocol error")
+
+// Serve accepts connections on ln until Close is called, when it returns nil
+func (s *RESPServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = ln.Close()
+		return nil
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		if !s.track(conn) {
+			_ = conn.Close()
+			return nil
+		}
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// Close stops listening and closes every connection
+func (s *RESPServer) Close() error {
+	s.mu.Lock()
+
+	s.closed = true
+
+	var firstErr error
+	for ln := range s.listeners {
+		if err := ln.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return firstErr
+}
+
+func (s *RESPServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *RESPServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				writeRESP(w, respError("ERR "+err.Error()))
+				_ = w.Flush()
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := strings.EqualFold(args[0], "QUIT")
+
+		if quit {
+			writeRESP(w, "OK")
+		} else {
+			writeRESP(w, s.exec(args))
+		}
+
+		// Replies to pipelined commands are sent at once
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+func (s *RESPServer) exec(args []string) any {
+	switch strings.ToUpper(args[0]) {
+	case "CL.THROTTLE":
+		return s.throttle(args[1:])
+	case "PING":
+		if len(args) > 1 {
+			return []byte(args[1])
+		}
+		return "PONG"
+	case "ECHO":
+		if len(args) != 2 {
+			return wrongArity(args[0])
+		}
+		return []byte(args[1])
+	case "COMMAND":
+		return []any{}
+	default:
+		return respError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+func (s *RESPServer) throttle(args []string) any {
+	if len(args) != 4 && len(args) != 5 {
+		return wrongArity("cl.throttle")
+	}
+
+	var (
+		limit    cellLimit
+		quantity int64 = 1
+	)
+
+	fields := []struct {
+		dst  *int64
+		name string
+		min  int64
+	}{
+		{dst: &limit.burst, name: "max_burst", min: 0},
+		{dst: &limit.count, name: "count", min: 1},
+		{dst: &limit.period, name: "period", min: 1},
+		{dst: &quantity, name: "quantity", min: 0},
+	}
+
+	for i, arg := range args[1:] {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < fields[i].min {
+			return respError(fmt.Sprintf("ERR invalid %s", fields[i].name))
+		}
+		*fields[i].dst = n
+	}
+
+	if name := limit.overflowing(); name != "" {
+		return respError(fmt.Sprintf("ERR invalid %s", name))
+	}
+
+	ctx := context.Background()
+	key := limit.String() + args[0]
+
+	var (
+		r   Result
+		err error
+	)
+
+	if quantity == 0 {
+		r, err = s.limiters.Dump(ctx, key)
+	} else {
+		r, err = s.limiters.Try(ctx, key, quantity)
+	}
+
+	capacity := limit.capacity()
+	limited := errors.Is(err, ErrRateLimitExceeded)
+
+	switch {
+	case errors.Is(err, ErrTokensGreaterThanCapacity):
+		return respError("ERR quantity is greater than max_burst + 1")
+	case err != nil && !limited:
+		return respError("ERR " + err.Error())
+	}
+
+	now := s.args.Clock.Now()
+	window := limit.window()
+
+	reply := []any{int64(0), capacity, max(r.FreeSlots, 0), int64(-1), int64(0)}
+
+	if limited {
+		reply[0] = int64(1)
+		reply[3] = ceilSeconds(r.TimeToWait)
+	}
+
+	if r.FreeSlots < capacity {
+		reply[4] = ceilSeconds(now.Truncate(window).Add(window).Sub(now))
+	}
+
+	return reply
+}
+
+func (l cellLimit) capacity() int64 {
+	return l.burst + 1
+}
+
+// window lasts as long as it takes to replenish the capacity at count per period. It is zero if the limit
+// overflows a time.Duration.
+func (l cellLimit) window() time.Duration {
+	if l.burst == math.MaxInt64 || l.period > math.MaxInt64/int64(time.Second) {
+		return 0
+	}
+
+	// period * capacity may overflow even if the window does not, so it is computed in 128 bits
+	hi, lo := bits.Mul64(uint64(l.period)*uint64(time.Second), uint64(l.capacity()))
+	if hi >= uint64(l.count) {
+		return 0
+	}
+
+	w, _ := bits.Div64(hi, lo, uint64(l.count))
+	if w > math.MaxInt64 {
+		return 0
+	}
+
+	return time.Duration(w)
+}
+
+// overflowing returns the name of the argument making the window overflow, if any. Periods fitting in a duration
+// only overflow along a burst too large to replenish in one.
+func (l cellLimit) overflowing() string {
+	if l.period > math.MaxInt64/int64(time.Second) {
+		return "period"
+	}
+
+	if l.window() <= 0 {
+		return "max_burst"
+	}
+
+	return ""
+}
+
+// String prefixes limiter keys, so that a key throttled with different limits is limited apart
+func (l cellLimit) String() string {
+	return fmt.Sprintf("%d:%d:%d:", l.burst, l.count, l.period)
+}
+
+func parseCellLimit(key string) (l cellLimit) {
+	parts := strings.SplitN(key, ":", 4)
+	l.burst, _ = strconv.ParseInt(parts[0], 10, 64)
+	l.count, _ = strconv.ParseInt(parts[1], 10, 64)
+	l.period, _ = strconv.ParseInt(parts[2], 10, 64)
+	return
+}
+
+func wrongArity(cmd string) respError {
+	return respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// readRESPCommand reads a command, either as an array of bulk strings or inline, as sent by telnet
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+
+	args := make([]string, 0, max(n, 0))
+
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errRESPProtocol, line)
+		}
+
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > respMaxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: invalid bulk terminator", errRESPProtocol)
+		}
+
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writeRESP writes v as a simple string, error, integer, bulk string or array thereof
+func writeRESP(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case respError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeRESP(w, e)
+		}
+	}
+}
+
+// NewRESPServer returns a new instance of RESPServer from struct of args. Call Serve to accept connections.
+func NewRESPServer(args RESPServerArgs) *RESPServer {
+	if args.Clock == nil {
+		args.Clock = NewClock()
+	}
+
+	if args.Storage == nil {
+		args.Storage = NewKeyedMemoryStorage(KeyedMemoryStorageOpts{Clock: args.Clock})
+	}
+
+	s := &RESPServer{
+		args:      args,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+
+	s.limiters = NewKeyedRateLimiter(KeyedRateLimiterArgs{
+		New: func(key string) TokenFixedWindowRateLimiter {
+			limit := parseCellLimit(key)
+
+			return NewTokenFixedWindowRateLimiter(NewFixedTruncatedWindowRateLimiter(FixedTruncatedWindowArgs{
+				Capacity: limit.capacity(),
+				Rate:     Rate{Amount: 1, Unit: limit.window()},
+				Clock:    args.Clock,
+				DB:       KeyedMemoryStorageScope{storage: args.Storage, key: "cell:" + key},
+			}))
+		},
+		MaxKeys: args.MaxKeys,
+	})
+
+	return s
+}
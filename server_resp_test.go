@@ -0,0 +1,197 @@
+package pacemaker_test
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sonirico/pacemaker"
+)
+
+// newRESPServer serves a RESPServer backed by memory, returning its address
+func newRESPServer(t *testing.T, clock *pacemaker.TestClock) string {
+	t.Helper()
+
+	return serveRESP(t, pacemaker.RESPServerArgs{
+		Storage: pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock}),
+		Clock:   clock,
+	})
+}
+
+// serveRESP serves a RESPServer built from args on localhost, returning its address
+func serveRESP(t *testing.T, args pacemaker.RESPServerArgs) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	srv := pacemaker.NewRESPServer(args)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Errorf("unexpected error closing, want none, have %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error serving, want none, have %v", err)
+		}
+	})
+
+	return ln.Addr().String()
+}
+
+func TestRESPServer_Throttle(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	cli := redis.NewClient(&redis.Options{Addr: newRESPServer(t, clock)})
+	t.Cleanup(func() { _ = cli.Close() })
+
+	// 3 actions every 4 seconds, in windows of 4 seconds
+	steps := []struct {
+		name          string
+		args          []interface{}
+		forward       time.Duration
+		expectedReply []interface{}
+		expectedErr   string
+	}{
+		{
+			name:          "query",
+			args:          []interface{}{"user", 2, 3, 4, 0},
+			expectedReply: []interface{}{int64(0), int64(3), int64(3), int64(-1), int64(0)},
+		},
+		{
+			name:          "allowed",
+			args:          []interface{}{"user", 2, 3, 4},
+			forward:       time.Second,
+			expectedReply: []interface{}{int64(0), int64(3), int64(2), int64(-1), int64(3)},
+		},
+		{
+			name:          "allowed quantity",
+			args:          []interface{}{"user", 2, 3, 4, 2},
+			expectedReply: []interface{}{int64(0), int64(3), int64(0), int64(-1), int64(3)},
+		},
+		{
+			name:          "limited",
+			args:          []interface{}{"user", 2, 3, 4},
+			forward:       time.Second,
+			expectedReply: []interface{}{int64(1), int64(3), int64(0), int64(2), int64(2)},
+		},
+		{
+			name:          "other limits are apart",
+			args:          []interface{}{"user", 5, 3, 4},
+			expectedReply: []interface{}{int64(0), int64(6), int64(5), int64(-1), int64(2)},
+		},
+		{
+			name:          "next window",
+			args:          []interface{}{"user", 2, 3, 4},
+			forward:       2 * time.Second,
+			expectedReply: []interface{}{int64(0), int64(3), int64(2), int64(-1), int64(4)},
+		},
+		{
+			name:        "quantity over the limit",
+			args:        []interface{}{"user", 2, 3, 4, 4},
+			expectedErr: "ERR quantity is greater than max_burst + 1",
+		},
+		{
+			name:        "invalid count",
+			args:        []interface{}{"user", 2, 0, 4},
+			expectedErr: "ERR invalid count",
+		},
+		{
+			name:        "burst overflowing the capacity",
+			args:        []interface{}{"user", int64(math.MaxInt64), 3, 4},
+			expectedErr: "ERR invalid max_burst",
+		},
+		{
+			name:        "burst overflowing the window",
+			args:        []interface{}{"user", int64(1) << 34, 1, int64(1) << 30},
+			expectedErr: "ERR invalid max_burst",
+		},
+		{
+			name:        "period overflowing the window",
+			args:        []interface{}{"user", 2, 3, int64(math.MaxInt64/int64(time.Second)) + 1},
+			expectedErr: "ERR invalid period",
+		},
+		{
+			name:        "wrong arity",
+			args:        []interface{}{"user", 2, 3},
+			expectedErr: "ERR wrong number of arguments for 'cl.throttle' command",
+		},
+	}
+
+	for _, step := range steps {
+		clock.Forward(step.forward)
+
+		reply, err := cli.Do(ctx, append([]interface{}{"CL.THROTTLE"}, step.args...)...).Result()
+
+		if step.expectedErr != "" {
+			if err == nil || err.Error() != step.expectedErr {
+				t.Errorf("%s: unexpected error, want %q, have %v", step.name, step.expectedErr, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: unexpected error, want none, have %v", step.name, err)
+		}
+
+		if !reflect.DeepEqual(reply, step.expectedReply) {
+			t.Errorf("%s: unexpected reply, want %v, have %v", step.name, step.expectedReply, reply)
+		}
+	}
+
+	if pong, err := cli.Ping(ctx).Result(); err != nil || pong != "PONG" {
+		t.Errorf("unexpected ping reply, want PONG, have %q, %v", pong, err)
+	}
+}
+
+func TestRESPServer_Inline(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	conn, err := net.Dial("tcp", newRESPServer(t, clock))
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("CL.THROTTLE user 0 1 60\r\nNOPE\r\nQUIT\r\n")); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	expected := "*5 :0 :1 :0 :-1 :60 -ERR unknown command 'NOPE' +OK"
+	if have := strings.Join(lines, " "); have != expected {
+		t.Errorf("unexpected replies, want %q, have %q", expected, have)
+	}
+}
+
+func TestRESPServer_ZeroArgs(t *testing.T) {
+	cli := redis.NewClient(&redis.Options{Addr: serveRESP(t, pacemaker.RESPServerArgs{})})
+	t.Cleanup(func() { _ = cli.Close() })
+
+	reply, err := cli.Do(context.Background(), "CL.THROTTLE", "user", 2, 3, 4).Slice()
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	// The reset time depends on the real clock, so only the limit and what remains of it are checked
+	if expected := []interface{}{int64(0), int64(3), int64(2), int64(-1)}; len(reply) != 5 || !reflect.DeepEqual(reply[:4], expected) {
+		t.Errorf("unexpected reply, want %v followed by the reset time, have %v", expected, reply)
+	}
+}
@@ -0,0 +1,364 @@
+package pacemaker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// RLSConfig maps the descriptors of a domain to rate limits, as the configuration of the reference Envoy
+	// rate limit service does, e.g.
+	//
+	//	{
+	//	  "domain": "edge",
+	//	  "descriptors": [
+	//	    {"key": "remote_address", "rate_limit": {"unit": "minute", "requests_per_unit": 60}},
+	//	    {"key": "path", "value": "/login", "descriptors": [
+	//	      {"key": "remote_address", "rate_limit": {"unit": "hour", "requests_per_unit": 10}}
+	//	    ]}
+	//	  ]
+	//	}
+	//
+	// limits every client address to 60 requests per minute, and 10 logins per hour.
+	RLSConfig struct {
+		Domain      string                `json:"domain"`
+		Descriptors []RLSDescriptorConfig `json:"descriptors"`
+	}
+
+	// RLSDescriptorConfig matches a descriptor entry by key and, if set, value. Entries of any value are limited
+	// apart, e.g. per client address. Nested descriptors match the following entries.
+	RLSDescriptorConfig struct {
+		Key         string                `json:"key"`
+		Value       string                `json:"value,omitempty"`
+		RateLimit   *RLSRateLimit         `json:"rate_limit,omitempty"`
+		Descriptors []RLSDescriptorConfig `json:"descriptors,omitempty"`
+	}
+
+	RLSRateLimit struct {
+		// Unit is one of second, minute, hour or day
+		Unit            string `json:"unit"`
+		RequestsPerUnit int64  `json:"requests_per_unit"`
+	}
+
+	RLSServerArgs struct {
+		Configs []RLSConfig
+		// Storage holds the counters of every descriptor. Defaults to a KeyedMemoryStorage.
+		Storage keyedStorage
+		// MaxKeys bounds how many rate limiters are kept per rate limit, see KeyedRateLimiterArgs
+		MaxKeys int
+		// Clock defaults to RealClock
+		Clock clock
+	}
+
+	// RLSRequest asks whether to rate limit a request described by descriptors, each one being a list of entries.
+	// It is the JSON form of the ShouldRateLimit request of the Envoy rate limit service.
+	RLSRequest struct {
+		Domain      string          `json:"domain"`
+		Descriptors []RLSDescriptor `json:"descriptors"`
+		// HitsAddend is how many tokens the request costs. Defaults to 1.
+		HitsAddend int64 `json:"hitsAddend,omitempty"`
+	}
+
+	RLSDescriptor struct {
+		Entries []RLSEntry `json:"entries"`
+	}
+
+	RLSEntry struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+
+	// RLSResponse holds a status per descriptor of the request, in order. OverallCode is OVER_LIMIT if any of
+	// them is.
+	RLSResponse struct {
+		OverallCode string      `json:"overallCode"`
+		Statuses    []RLSStatus `json:"statuses"`
+	}
+
+	// RLSStatus is the status of a descriptor. Descriptors matching no rate limit are OK, with no CurrentLimit.
+	RLSStatus struct {
+		Code               string           `json:"code"`
+		CurrentLimit       *RLSCurrentLimit `json:"currentLimit,omitempty"`
+		LimitRemaining     int64            `json:"limitRemaining"`
+		DurationUntilReset string           `json:"durationUntilReset,omitempty"`
+	}
+
+	RLSCurrentLimit struct {
+		RequestsPerUnit int64  `json:"requestsPerUnit"`
+		Unit            string `json:"unit"`
+	}
+
+	// RLSServer is an http.Handler implementing the rate limit service Envoy proxies call, in its JSON form, at
+	// POST /json. Descriptors are mapped to rate limits by RLSConfig, each one enforced by a KeyedRateLimiter
+	// over fixed truncated windows of its unit.
+	RLSServer struct {
+		args    RLSServerArgs
+		domains map[string][]*rlsNode
+	}
+
+	// rlsNode is a compiled RLSDescriptorConfig
+	rlsNode struct {
+		key      string
+		value    string
+		limit    *RLSCurrentLimit
+		unit     time.Duration
+		limiter  *KeyedRateLimiter
+		children []*rlsNode
+	}
+)
+
+const (
+	RLSCodeOK        = "OK"
+	RLSCodeOverLimit = "OVER_LIMIT"
+
+	rlsPath = "/json"
+)
+
+var rlsUnits = map[string]time.Duration{
+	"SECOND": time.Second,
+	"MINUTE": time.Minute,
+	"HOUR":   time.Hour,
+	"DAY":    24 * time.Hour,
+}
+
+// ShouldRateLimit consumes the rate limit of every descriptor of req matching a configured one
+func (s *RLSServer) ShouldRateLimit(ctx context.Context, req RLSRequest) (RLSResponse, error) {
+	nodes, ok := s.domains[req.Domain]
+	if !ok {
+		return RLSResponse{}, fmt.Errorf("%w: %q", ErrUnknownDomain, req.Domain)
+	}
+
+	hits := req.HitsAddend
+	if hits <= 0 {
+		hits = 1
+	}
+
+	res := RLSResponse{OverallCode: RLSCodeOK, Statuses: make([]RLSStatus, len(req.Descriptors))}
+
+	for i, d := range req.Descriptors {
+		status, err := s.limit(ctx, req.Domain, nodes, d, hits)
+		if err != nil {
+			return RLSResponse{}, err
+		}
+
+		if status.Code == RLSCodeOverLimit {
+			res.OverallCode = RLSCodeOverLimit
+		}
+
+		res.Statuses[i] = status
+	}
+
+	return res, nil
+}
+
+func (s *RLSServer) limit(
+	ctx context.Context,
+	domain string,
+	nodes []*rlsNode,
+	d RLSDescriptor,
+	hits int64,
+) (RLSStatus, error) {
+	node := matchRLSNode(nodes, d.Entries)
+	if node == nil || node.limiter == nil {
+		return RLSStatus{Code: RLSCodeOK}, nil
+	}
+
+	r, err := node.limiter.Try(ctx, rlsKey(domain, d.Entries), hits)
+
+	status := RLSStatus{
+		Code:           RLSCodeOK,
+		CurrentLimit:   node.limit,
+		LimitRemaining: max(r.FreeSlots, 0),
+	}
+
+	now := s.args.Clock.Now()
+	reset := now.Truncate(node.unit).Add(node.unit).Sub(now)
+
+	switch {
+	case errors.Is(err, ErrRateLimitExceeded), errors.Is(err, ErrTokensGreaterThanCapacity):
+		status.Code = RLSCodeOverLimit
+		status.LimitRemaining = 0
+		if r.TimeToWait > 0 {
+			reset = r.TimeToWait
+		}
+	case err != nil:
+		return RLSStatus{}, err
+	}
+
+	status.DurationUntilReset = rlsDuration(reset)
+
+	return status, nil
+}
+
+func (s *RLSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != rlsPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RLSRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.ShouldRateLimit(r.Context(), req)
+
+	switch {
+	case errors.Is(err, ErrUnknownDomain):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// matchRLSNode walks the config tree along entries, preferring nodes matching the value of an entry over the
+// ones matching any value. It returns nil unless every entry is matched.
+func matchRLSNode(nodes []*rlsNode, entries []RLSEntry) *rlsNode {
+	var node *rlsNode
+
+	for _, entry := range entries {
+		var match *rlsNode
+
+		for _, n := range nodes {
+			if n.key != entry.Key {
+				continue
+			}
+			if n.value == entry.Value {
+				match = n
+				break
+			}
+			if n.value == "" && match == nil {
+				match = n
+			}
+		}
+
+		if match == nil {
+			return nil
+		}
+
+		node, nodes = match, match.children
+	}
+
+	return node
+}
+
+func rlsKey(domain string, entries []RLSEntry) string {
+	var b strings.Builder
+
+	b.WriteString("rls:")
+	b.WriteString(domain)
+
+	for _, e := range entries {
+		b.WriteString("|")
+		b.WriteString(e.Key)
+		b.WriteString("=")
+		b.WriteString(e.Value)
+	}
+
+	return b.String()
+}
+
+// rlsDuration formats d as the JSON form of a protobuf Duration, in whole seconds
+func rlsDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", ceilSeconds(d))
+}
+
+func (s *RLSServer) compile(configs []RLSDescriptorConfig) ([]*rlsNode, error) {
+	nodes := make([]*rlsNode, 0, len(configs))
+
+	for _, c := range configs {
+		if c.Key == "" {
+			return nil, errors.New("rls: descriptor key cannot be empty")
+		}
+
+		n := &rlsNode{key: c.Key, value: c.Value}
+
+		if c.RateLimit != nil {
+			unit, ok := rlsUnits[strings.ToUpper(c.RateLimit.Unit)]
+			if !ok {
+				return nil, fmt.Errorf("rls: invalid unit %q of descriptor %q", c.RateLimit.Unit, c.Key)
+			}
+
+			if c.RateLimit.RequestsPerUnit <= 0 {
+				return nil, fmt.Errorf("rls: requests per unit of descriptor %q must be positive", c.Key)
+			}
+
+			n.unit = unit
+			n.limit = &RLSCurrentLimit{
+				RequestsPerUnit: c.RateLimit.RequestsPerUnit,
+				Unit:            strings.ToUpper(c.RateLimit.Unit),
+			}
+			n.limiter = s.newLimiter(c.RateLimit.RequestsPerUnit, unit)
+		}
+
+		children, err := s.compile(c.Descriptors)
+		if err != nil {
+			return nil, err
+		}
+		n.children = children
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+func (s *RLSServer) newLimiter(capacity int64, unit time.Duration) *KeyedRateLimiter {
+	return NewKeyedRateLimiter(KeyedRateLimiterArgs{
+		New: func(key string) TokenFixedWindowRateLimiter {
+			return NewTokenFixedWindowRateLimiter(NewFixedTruncatedWindowRateLimiter(FixedTruncatedWindowArgs{
+				Capacity: capacity,
+				Rate:     Rate{Amount: 1, Unit: unit},
+				Clock:    s.args.Clock,
+				DB:       KeyedMemoryStorageScope{storage: s.args.Storage, key: key},
+			}))
+		},
+		MaxKeys: s.args.MaxKeys,
+	})
+}
+
+// NewRLSServer returns a new instance of RLSServer from struct of args, or an error if its configs are invalid
+func NewRLSServer(args RLSServerArgs) (*RLSServer, error) {
+	if args.Clock == nil {
+		args.Clock = NewClock()
+	}
+
+	if args.Storage == nil {
+		args.Storage = NewKeyedMemoryStorage(KeyedMemoryStorageOpts{Clock: args.Clock})
+	}
+
+	s := &RLSServer{
+		args:    args,
+		domains: make(map[string][]*rlsNode, len(args.Configs)),
+	}
+
+	for _, c := range args.Configs {
+		if _, ok := s.domains[c.Domain]; ok {
+			return nil, fmt.Errorf("rls: duplicated domain %q", c.Domain)
+		}
+
+		nodes, err := s.compile(c.Descriptors)
+		if err != nil {
+			return nil, err
+		}
+
+		s.domains[c.Domain] = nodes
+	}
+
+	return s, nil
+}
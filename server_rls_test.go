@@ -0,0 +1,255 @@
+package pacemaker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+const rlsConfig = `{
+  "domain": "edge",
+  "descriptors": [
+    {"key": "remote_address", "rate_limit": {"unit": "minute", "requests_per_unit": 3}},
+    {"key": "path", "value": "/login", "descriptors": [
+      {"key": "remote_address", "rate_limit": {"unit": "hour", "requests_per_unit": 1}}
+    ]},
+    {"key": "path"}
+  ]
+}`
+
+func newRLSServer(t *testing.T, clock *pacemaker.TestClock) *pacemaker.RLSServer {
+	t.Helper()
+
+	var config pacemaker.RLSConfig
+	if err := json.Unmarshal([]byte(rlsConfig), &config); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	srv, err := pacemaker.NewRLSServer(pacemaker.RLSServerArgs{
+		Configs: []pacemaker.RLSConfig{config},
+		Storage: pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock}),
+		Clock:   clock,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	return srv
+}
+
+func rlsDescriptor(kv ...string) pacemaker.RLSDescriptor {
+	var d pacemaker.RLSDescriptor
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, pacemaker.RLSEntry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func TestRLSServer_ShouldRateLimit(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	srv := newRLSServer(t, clock)
+
+	perMinute := &pacemaker.RLSCurrentLimit{RequestsPerUnit: 3, Unit: "MINUTE"}
+	perHour := &pacemaker.RLSCurrentLimit{RequestsPerUnit: 1, Unit: "HOUR"}
+
+	steps := []struct {
+		name        string
+		descriptors []pacemaker.RLSDescriptor
+		hits        int64
+		forward     time.Duration
+		expected    pacemaker.RLSResponse
+	}{
+		{
+			name: "allowed",
+			descriptors: []pacemaker.RLSDescriptor{
+				rlsDescriptor("remote_address", "10.0.0.1"),
+				rlsDescriptor("path", "/login", "remote_address", "10.0.0.1"),
+			},
+			forward: 15 * time.Second,
+			expected: pacemaker.RLSResponse{
+				OverallCode: pacemaker.RLSCodeOK,
+				Statuses: []pacemaker.RLSStatus{
+					{Code: "OK", CurrentLimit: perMinute, LimitRemaining: 2, DurationUntilReset: "45s"},
+					{Code: "OK", CurrentLimit: perHour, LimitRemaining: 0, DurationUntilReset: "2205s"},
+				},
+			},
+		},
+		{
+			name: "any descriptor over the limit",
+			descriptors: []pacemaker.RLSDescriptor{
+				rlsDescriptor("remote_address", "10.0.0.1"),
+				rlsDescriptor("path", "/login", "remote_address", "10.0.0.1"),
+			},
+			expected: pacemaker.RLSResponse{
+				OverallCode: pacemaker.RLSCodeOverLimit,
+				Statuses: []pacemaker.RLSStatus{
+					{Code: "OK", CurrentLimit: perMinute, LimitRemaining: 1, DurationUntilReset: "45s"},
+					{Code: "OVER_LIMIT", CurrentLimit: perHour, LimitRemaining: 0, DurationUntilReset: "2205s"},
+				},
+			},
+		},
+		{
+			name:        "hits addend",
+			descriptors: []pacemaker.RLSDescriptor{rlsDescriptor("remote_address", "10.0.0.1")},
+			hits:        2,
+			expected: pacemaker.RLSResponse{
+				OverallCode: pacemaker.RLSCodeOverLimit,
+				Statuses: []pacemaker.RLSStatus{
+					{Code: "OVER_LIMIT", CurrentLimit: perMinute, LimitRemaining: 0, DurationUntilReset: "45s"},
+				},
+			},
+		},
+		{
+			name: "values are limited apart",
+			descriptors: []pacemaker.RLSDescriptor{
+				rlsDescriptor("remote_address", "10.0.0.2"),
+				rlsDescriptor("path", "/login", "remote_address", "10.0.0.2"),
+			},
+			expected: pacemaker.RLSResponse{
+				OverallCode: pacemaker.RLSCodeOK,
+				Statuses: []pacemaker.RLSStatus{
+					{Code: "OK", CurrentLimit: perMinute, LimitRemaining: 2, DurationUntilReset: "45s"},
+					{Code: "OK", CurrentLimit: perHour, LimitRemaining: 0, DurationUntilReset: "2205s"},
+				},
+			},
+		},
+		{
+			name: "unlimited descriptors",
+			descriptors: []pacemaker.RLSDescriptor{
+				rlsDescriptor("path", "/home"),
+				rlsDescriptor("user", "alice"),
+			},
+			expected: pacemaker.RLSResponse{
+				OverallCode: pacemaker.RLSCodeOK,
+				Statuses:    []pacemaker.RLSStatus{{Code: "OK"}, {Code: "OK"}},
+			},
+		},
+		{
+			name:        "next window",
+			descriptors: []pacemaker.RLSDescriptor{rlsDescriptor("remote_address", "10.0.0.1")},
+			forward:     time.Minute,
+			expected: pacemaker.RLSResponse{
+				OverallCode: pacemaker.RLSCodeOK,
+				Statuses: []pacemaker.RLSStatus{
+					{Code: "OK", CurrentLimit: perMinute, LimitRemaining: 2, DurationUntilReset: "45s"},
+				},
+			},
+		},
+	}
+
+	for _, step := range steps {
+		clock.Forward(step.forward)
+
+		res, err := srv.ShouldRateLimit(ctx, pacemaker.RLSRequest{
+			Domain:      "edge",
+			Descriptors: step.descriptors,
+			HitsAddend:  step.hits,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error, want none, have %v", step.name, err)
+		}
+
+		if !reflect.DeepEqual(res, step.expected) {
+			t.Errorf("%s: unexpected response, want %+v, have %+v", step.name, step.expected, res)
+		}
+	}
+
+	_, err := srv.ShouldRateLimit(ctx, pacemaker.RLSRequest{Domain: "nope"})
+	if !errors.Is(err, pacemaker.ErrUnknownDomain) {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrUnknownDomain, err)
+	}
+}
+
+func TestRLSServer_HTTP(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	srv := httptest.NewServer(newRLSServer(t, clock))
+	t.Cleanup(srv.Close)
+
+	body := `{"domain": "edge", "descriptors": [{"entries": [{"key": "remote_address", "value": "10.0.0.1"}]}]}`
+
+	resp, err := http.Post(srv.URL+"/json", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res pacemaker.RLSResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if res.OverallCode != pacemaker.RLSCodeOK || len(res.Statuses) != 1 || res.Statuses[0].LimitRemaining != 2 {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
+
+func TestRLSServer_DefaultStorage(t *testing.T) {
+	var config pacemaker.RLSConfig
+	if err := json.Unmarshal([]byte(rlsConfig), &config); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	srv, err := pacemaker.NewRLSServer(pacemaker.RLSServerArgs{Configs: []pacemaker.RLSConfig{config}})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	res, err := srv.ShouldRateLimit(context.Background(), pacemaker.RLSRequest{
+		Domain:      "edge",
+		Descriptors: []pacemaker.RLSDescriptor{rlsDescriptor("remote_address", "10.0.0.1")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if res.OverallCode != pacemaker.RLSCodeOK || len(res.Statuses) != 1 || res.Statuses[0].LimitRemaining != 2 {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
+
+func TestNewRLSServer_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config pacemaker.RLSConfig
+	}{
+		{
+			name: "invalid unit",
+			config: pacemaker.RLSConfig{Domain: "edge", Descriptors: []pacemaker.RLSDescriptorConfig{
+				{Key: "user", RateLimit: &pacemaker.RLSRateLimit{Unit: "week", RequestsPerUnit: 1}},
+			}},
+		},
+		{
+			name: "no requests",
+			config: pacemaker.RLSConfig{Domain: "edge", Descriptors: []pacemaker.RLSDescriptorConfig{
+				{Key: "user", RateLimit: &pacemaker.RLSRateLimit{Unit: "second"}},
+			}},
+		},
+		{
+			name: "empty key",
+			config: pacemaker.RLSConfig{Domain: "edge", Descriptors: []pacemaker.RLSDescriptorConfig{
+				{Descriptors: []pacemaker.RLSDescriptorConfig{{Key: "user"}}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := pacemaker.NewRLSServer(pacemaker.RLSServerArgs{
+				Configs: []pacemaker.RLSConfig{test.config},
+				Storage: pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{}),
+			})
+			if err == nil {
+				t.Error("expected error, have none")
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package pacemaker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// keyedStorage holds the rate limit state of many keys, such as KeyedMemoryStorage
+	keyedStorage interface {
+		Inc(ctx context.Context, key string, args FixedWindowIncArgs) (int64, error)
+		Get(ctx context.Context, key string, window time.Time) (int64, error)
+		LastWindow(ctx context.Context, key string) (time.Time, error)
+	}
+
+	TokenServerArgs struct {
+		// Storage holds the counters of FixedWindowRemoteStorage clients. If nil, storage operations are not
+		// served.
+		Storage keyedStorage
+		// Limiters are served by name
+		Limiters map[string]*KeyedRateLimiter
+	}
+
+	// TokenServer is an http.Handler serving rate limits over HTTP/JSON, so that many services share them
+	// without each one needing access to the storage. It serves two APIs:
+	//
+	//	POST /v1/storage                    batch of storage operations, used by FixedWindowRemoteStorage
+	//	POST /v1/limiters/{name}/try        {"key": "...", "tokens": 1}
+	//	POST /v1/limiters/{name}/check      {"key": "...", "tokens": 1}
+	//	POST /v1/limiters/{name}/dump       {"key": "..."}
+	//
+	// Limiter operations reply with the Result, as {"time_to_wait": ns, "free_slots": n}, and 429 Too Many
+	// Requests when the rate limit is exceeded.
+	TokenServer struct {
+		args TokenServerArgs
+	}
+
+	storageBatchRequest struct {
+		Ops []storageOp `json:"ops"`
+	}
+
+	storageBatchResponse struct {
+		Results []storageOpResult `json:"results"`
+	}
+
+	storageOp struct {
+		Op       string `json:"op"`
+		Key      string `json:"key"`
+		Window   int64  `json:"window,omitempty"`
+		TTL      int64  `json:"ttl,omitempty"`
+		Tokens   int64  `json:"tokens,omitempty"`
+		Capacity int64  `json:"capacity,omitempty"`
+	}
+
+	storageOpResult struct {
+		Counter int64  `json:"counter,omitempty"`
+		Window  int64  `json:"window,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	limiterRequest struct {
+		Key    string `json:"key"`
+		Tokens int64  `json:"tokens"`
+	}
+
+	limiterResponse struct {
+		TimeToWait time.Duration `json:"time_to_wait"`
+		FreeSlots  int64         `json:"free_slots"`
+		Error      string        `json:"error,omitempty"`
+	}
+)
+
+const (
+	storageOpInc        = "inc"
+	storageOpGet        = "get"
+	storageOpLastWindow = "last_window"
+
+	tokenServerStoragePath  = "/v1/storage"
+	tokenServerLimitersPath = "/v1/limiters/"
+)
+
+// wireErrors are the errors sent over the wire by code, so that clients can match them with errors.Is
+var wireErrors = map[string]error{
+	"rate_limit_exceeded":          ErrRateLimitExceeded,
+	"tokens_greater_than_capacity": ErrTokensGreaterThanCapacity,
+	"no_last_key":                  ErrNoLastKey,
+	"too_many_conflicts":           ErrTooManyConflicts,
+}
+
+func (s *TokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case r.URL.Path == tokenServerStoragePath && s.args.Storage != nil:
+		s.serveStorage(w, r)
+	case strings.HasPrefix(r.URL.Path, tokenServerLimitersPath):
+		s.serveLimiter(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *TokenServer) serveStorage(w http.ResponseWriter, r *http.Request) {
+	var req storageBatchRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	res := storageBatchResponse{Results: make([]storageOpResult, len(req.Ops))}
+
+	for i, op := range req.Ops {
+		var (
+			result storageOpResult
+			err    error
+		)
+
+		switch op.Op {
+		case storageOpInc:
+			result.Counter, err = s.args.Storage.Inc(ctx, op.Key, FixedWindowIncArgs{
+				Window:   time.Unix(0, op.Window),
+				TTL:      time.Duration(op.TTL),
+				Tokens:   op.Tokens,
+				Capacity: op.Capacity,
+			})
+		case storageOpGet:
+			result.Counter, err = s.args.Storage.Get(ctx, op.Key, time.Unix(0, op.Window))
+		case storageOpLastWindow:
+			var window time.Time
+			window, err = s.args.Storage.LastWindow(ctx, op.Key)
+			if err == nil && !window.IsZero() {
+				result.Window = window.UnixNano()
+			}
+		default:
+			err = errors.New("unknown operation " + op.Op)
+		}
+
+		if err != nil {
+			result.Error = encodeWireError(err)
+		}
+
+		res.Results[i] = result
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *TokenServer) serveLimiter(w http.ResponseWriter, r *http.Request) {
+	name, op, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, tokenServerLimitersPath), "/")
+
+	limiter := s.args.Limiters[name]
+
+	if !ok || limiter == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req limiterRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		res Result
+		err error
+	)
+
+	switch op {
+	case "try":
+		res, err = limiter.Try(r.Context(), req.Key, req.Tokens)
+	case "check":
+		res, err = limiter.Check(r.Context(), req.Key, req.Tokens)
+	case "dump":
+		res, err = limiter.Dump(r.Context(), req.Key)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	status := http.StatusOK
+
+	switch {
+	case errors.Is(err, ErrRateLimitExceeded):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, ErrTokensGreaterThanCapacity):
+		status = http.StatusBadRequest
+	case err != nil:
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, limiterResponse{
+		TimeToWait: res.TimeToWait,
+		FreeSlots:  res.FreeSlots,
+		Error:      encodeWireError(err),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func encodeWireError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	for code, e := range wireErrors {
+		if errors.Is(err, e) {
+			return code
+		}
+	}
+
+	return err.Error()
+}
+
+func decodeWireError(code string) error {
+	if code == "" {
+		return nil
+	}
+
+	if err, ok := wireErrors[code]; ok {
+		return err
+	}
+
+	return errors.New(code)
+}
+
+// NewTokenServer returns a new instance of TokenServer from struct of args
+func NewTokenServer(args TokenServerArgs) *TokenServer {
+	return &TokenServer{args: args}
+}
@@ -0,0 +1,137 @@
+package pacemaker_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+func newKeyedLimiter(clock *pacemaker.TestClock, capacity int64, maxKeys int) *pacemaker.KeyedRateLimiter {
+	storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock})
+
+	return pacemaker.NewKeyedRateLimiter(pacemaker.KeyedRateLimiterArgs{
+		New: func(key string) pacemaker.TokenFixedWindowRateLimiter {
+			return pacemaker.NewTokenFixedWindowRateLimiter(
+				pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+					Capacity: capacity,
+					Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+					Clock:    clock,
+					DB:       storage.For(key),
+				}),
+			)
+		},
+		MaxKeys: maxKeys,
+	})
+}
+
+func TestTokenServer_Limiters(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	srv, _ := newTokenServer(t, clock, pacemaker.TokenServerArgs{
+		Limiters: map[string]*pacemaker.KeyedRateLimiter{"api": newKeyedLimiter(clock, 3, 0)},
+	})
+
+	tests := []struct {
+		name              string
+		path              string
+		body              string
+		expectedStatus    int
+		expectedFreeSlots int64
+		expectedError     string
+	}{
+		{
+			name:              "try",
+			path:              "/v1/limiters/api/try",
+			body:              `{"key": "alice", "tokens": 2}`,
+			expectedStatus:    http.StatusOK,
+			expectedFreeSlots: 1,
+		},
+		{
+			name:              "check does not consume",
+			path:              "/v1/limiters/api/check",
+			body:              `{"key": "alice", "tokens": 1}`,
+			expectedStatus:    http.StatusOK,
+			expectedFreeSlots: 1,
+		},
+		{
+			name:              "try over the limit",
+			path:              "/v1/limiters/api/try",
+			body:              `{"key": "alice", "tokens": 2}`,
+			expectedStatus:    http.StatusTooManyRequests,
+			expectedFreeSlots: 0,
+			expectedError:     "rate_limit_exceeded",
+		},
+		{
+			name:              "keys are limited apart",
+			path:              "/v1/limiters/api/dump",
+			body:              `{"key": "bob"}`,
+			expectedStatus:    http.StatusOK,
+			expectedFreeSlots: 3,
+		},
+		{
+			name:           "tokens over capacity",
+			path:           "/v1/limiters/api/try",
+			body:           `{"key": "bob", "tokens": 4}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "tokens_greater_than_capacity",
+		},
+		{
+			name:           "unknown limiter",
+			path:           "/v1/limiters/web/try",
+			body:           `{"key": "alice", "tokens": 1}`,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+test.path, "application/json", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != test.expectedStatus {
+				t.Fatalf("unexpected status, want %d, have %d", test.expectedStatus, resp.StatusCode)
+			}
+
+			if resp.StatusCode == http.StatusNotFound {
+				return
+			}
+
+			var res struct {
+				FreeSlots int64  `json:"free_slots"`
+				Error     string `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			if res.FreeSlots != test.expectedFreeSlots {
+				t.Errorf("unexpected free slots, want %d, have %d", test.expectedFreeSlots, res.FreeSlots)
+			}
+
+			if res.Error != test.expectedError {
+				t.Errorf("unexpected error, want %q, have %q", test.expectedError, res.Error)
+			}
+		})
+	}
+}
+
+func TestTokenServer_MethodNotAllowed(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	srv, _ := newTokenServer(t, clock, pacemaker.TokenServerArgs{})
+
+	resp, err := http.Get(srv.URL + "/v1/storage")
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status, want %d, have %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
@@ -0,0 +1,87 @@
+package pacemaker
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type (
+	// snapshot is the versioned format produced by Snapshot methods, so that rate limit state survives restarts
+	snapshot struct {
+		Version int              `json:"version"`
+		TakenAt time.Time        `json:"taken_at"`
+		Windows []snapshotWindow `json:"windows"`
+	}
+
+	// snapshotWindow is the state of one key. Window identifies the window the same way storages receive it from
+	// limiters, while TTL is how long it had left to live when the snapshot was taken.
+	snapshotWindow struct {
+		Key     string        `json:"key,omitempty"`
+		Window  time.Time     `json:"window"`
+		Counter int64         `json:"counter"`
+		TTL     time.Duration `json:"ttl"`
+	}
+)
+
+const (
+	snapshotVersion = 1
+)
+
+func encodeSnapshot(takenAt time.Time, windows []snapshotWindow) ([]byte, error) {
+	if windows == nil {
+		windows = []snapshotWindow{}
+	}
+
+	return json.Marshal(snapshot{
+		Version: snapshotVersion,
+		TakenAt: takenAt,
+		Windows: windows,
+	})
+}
+
+// decodeSnapshot parses data, dropping the windows whose TTL elapsed by now
+func decodeSnapshot(data []byte, now time.Time) (snapshot, error) {
+	s, err := parseSnapshot(data)
+	if err != nil {
+		return s, err
+	}
+
+	alive := s.Windows[:0]
+
+	for _, w := range s.Windows {
+		if s.expiresAt(w).After(now) {
+			alive = append(alive, w)
+		}
+	}
+
+	s.Windows = alive
+
+	return s, nil
+}
+
+func parseSnapshot(data []byte) (s snapshot, err error) {
+	if err = json.Unmarshal(data, &s); err != nil {
+		return
+	}
+
+	if s.Version != snapshotVersion {
+		err = ErrUnsupportedSnapshotVersion
+	}
+
+	return
+}
+
+// expiresAt returns when w expires
+func (s snapshot) expiresAt(w snapshotWindow) time.Time {
+	return s.TakenAt.Add(w.TTL)
+}
+
+// latest returns the latest window of the snapshot, for storages and limiters holding a single one
+func (s snapshot) latest() (latest snapshotWindow, ok bool) {
+	for _, w := range s.Windows {
+		if !ok || w.Window.After(latest.Window) {
+			latest, ok = w, true
+		}
+	}
+	return
+}
@@ -0,0 +1,219 @@
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type snapshotStorage interface {
+	fixedTruncatedWindowStorage
+	Snapshot(ctx context.Context) ([]byte, error)
+	Restore(ctx context.Context, data []byte) error
+}
+
+func TestMemoryStorage_SnapshotRestore(t *testing.T) {
+	start := time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC)
+	window := start.Add(10 * time.Second)
+
+	storages := []struct {
+		name string
+		new  func(clock clock) snapshotStorage
+	}{
+		{
+			name: "fixed window",
+			new: func(clock clock) snapshotStorage {
+				return NewFixedWindowMemoryStorageWithClock(clock)
+			},
+		},
+		{
+			name: "fixed truncated window",
+			new: func(clock clock) snapshotStorage {
+				return NewFixedTruncatedWindowMemoryStorageWithClock(clock)
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		passTime        time.Duration
+		expectedCounter int64
+	}{
+		{
+			name:            "window is restored",
+			passTime:        5 * time.Second,
+			expectedCounter: 3,
+		},
+		{
+			name:            "expired window is dropped",
+			passTime:        10 * time.Second,
+			expectedCounter: 0,
+		},
+	}
+
+	for _, storage := range storages {
+		for _, test := range tests {
+			t.Run(storage.name+"/"+test.name, func(t *testing.T) {
+				ctx := context.Background()
+				clock := NewMockClock(start)
+
+				src := storage.new(clock)
+
+				_, err := src.Inc(ctx, FixedWindowIncArgs{
+					Window:   window,
+					TTL:      10 * time.Second,
+					Tokens:   3,
+					Capacity: 10,
+				})
+				if err != nil {
+					t.Fatalf("unexpected error, want none, have %v", err)
+				}
+
+				data, err := src.Snapshot(ctx)
+				if err != nil {
+					t.Fatalf("unexpected error, want none, have %v", err)
+				}
+
+				clock.Forward(test.passTime)
+
+				dst := storage.new(clock)
+
+				if err := dst.Restore(ctx, data); err != nil {
+					t.Fatalf("unexpected error, want none, have %v", err)
+				}
+
+				counter, err := dst.Get(ctx, window)
+				if err != nil {
+					t.Fatalf("unexpected error, want none, have %v", err)
+				}
+
+				if counter != test.expectedCounter {
+					t.Errorf("unexpected counter, want %d, have %d", test.expectedCounter, counter)
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshot_UnsupportedVersion(t *testing.T) {
+	storage := NewFixedWindowMemoryStorage()
+
+	err := storage.Restore(context.Background(), []byte(`{"version":2,"windows":[]}`))
+
+	if !errors.Is(err, ErrUnsupportedSnapshotVersion) {
+		t.Errorf("unexpected error, want %v, have %v", ErrUnsupportedSnapshotVersion, err)
+	}
+}
+
+func TestFixedWindowRateLimiter_SnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	clock := NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+	newLimiter := func() *FixedWindowRateLimiter {
+		return NewFixedWindowRateLimiter(FixedWindowArgs{
+			Capacity: 3,
+			Rate:     Rate{Amount: 10, Unit: time.Second},
+			Clock:    clock,
+			DB:       NewFixedWindowMemoryStorage(),
+		})
+	}
+
+	before := newLimiter()
+
+	for i := 0; i < 2; i++ {
+		if _, err := before.Try(ctx); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	data, err := before.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	clock.Forward(4 * time.Second)
+
+	after := newLimiter()
+
+	if err := after.Restore(ctx, data); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	r, err := after.Try(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if r.FreeSlots != 0 {
+		t.Errorf("unexpected free slots, want 0, have %d", r.FreeSlots)
+	}
+
+	r, err = after.Try(ctx)
+	if !errors.Is(err, ErrRateLimitExceeded) {
+		t.Fatalf("unexpected error, want %v, have %v", ErrRateLimitExceeded, err)
+	}
+
+	if r.TimeToWait != 6*time.Second {
+		t.Errorf("unexpected time to wait, want %v, have %v", 6*time.Second, r.TimeToWait)
+	}
+
+	clock.Forward(6 * time.Second)
+
+	late := newLimiter()
+
+	if err := late.Restore(ctx, data); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	r, err = late.Try(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if r.FreeSlots != 2 {
+		t.Errorf("unexpected free slots, want 2, have %d", r.FreeSlots)
+	}
+}
+
+func TestTokenFixedWindowRateLimiter_SnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	clock := NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+	newLimiter := func() TokenFixedWindowRateLimiter {
+		return NewTokenFixedWindowRateLimiter(NewFixedTruncatedWindowRateLimiter(FixedTruncatedWindowArgs{
+			Capacity: 10,
+			Rate:     Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       NewFixedTruncatedWindowMemoryStorage(),
+		}))
+	}
+
+	before := newLimiter()
+
+	if _, err := before.Try(ctx, 7); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	data, err := before.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	clock.Forward(10 * time.Second)
+
+	after := newLimiter()
+
+	if err := after.Restore(ctx, data); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	r, err := after.Check(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if r.FreeSlots != 3 {
+		t.Errorf("unexpected free slots, want 3, have %d", r.FreeSlots)
+	}
+}
@@ -0,0 +1,282 @@
+package pacemaker
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	FixedWindowBoltStorageOpts struct {
+		// Key identifies the rate limit within the database file. Several limiters can share the same file
+		// as long as their keys differ.
+		Key string
+		// Clock is used to expire stale windows. Defaults to RealClock.
+		Clock clock
+	}
+
+	// FixedWindowBoltStorage persists the rate limit state in a local bbolt database file. Preferred option for
+	// standalone instances of your program that need to keep the rate limit state across restarts without the
+	// burden of running redis. Every update is committed in its own transaction, thus surviving crashes.
+	FixedWindowBoltStorage struct {
+		db *bolt.DB
+
+		opts FixedWindowBoltStorageOpts
+
+		key []byte
+	}
+)
+
+const (
+	boltRecordLen = 16
+)
+
+var (
+	boltRootBucket = []byte("pacemaker")
+)
+
+// Inc will increase, if there is room to, the rate limiting counter for the bucket
+// specified by window argument.
+func (s FixedWindowBoltStorage) Inc(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (counter int64, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	now := s.opts.Clock.Now()
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if _, err = expireBoltWindows(b, now); err != nil {
+			return err
+		}
+
+		k := boltWindowKey(args.Window)
+
+		current, _ := decodeBoltRecord(b.Get(k), now)
+		counter = current + args.Tokens
+
+		if counter > args.Capacity {
+			return nil
+		}
+
+		return b.Put(k, encodeBoltRecord(counter, now.Add(args.TTL)))
+	})
+
+	return
+}
+
+func (s FixedWindowBoltStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (counter int64, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	now := s.opts.Clock.Now()
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := s.lookupBucket(tx)
+		if b == nil {
+			return nil
+		}
+
+		counter, _ = decodeBoltRecord(b.Get(boltWindowKey(window)), now)
+		return nil
+	})
+
+	return
+}
+
+func (s FixedWindowBoltStorage) LastWindow(ctx context.Context) (ts time.Time, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	now := s.opts.Clock.Now()
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := s.lookupBucket(tx)
+		if b == nil {
+			return ErrNoLastKey
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if _, alive := decodeBoltRecord(v, now); alive {
+				ts = time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+				return nil
+			}
+		}
+
+		return ErrNoLastKey
+	})
+
+	return
+}
+
+// Snapshot returns the windows of this storage whose TTL has not elapsed, in the format of memory storage
+// snapshots. Mostly useful as the source of a migration, see Migrate.
+func (s FixedWindowBoltStorage) Snapshot(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := s.opts.Clock.Now()
+
+	var windows []snapshotWindow
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := s.lookupBucket(tx)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			counter, alive := decodeBoltRecord(v, now)
+			if !alive {
+				return nil
+			}
+
+			windows = append(windows, snapshotWindow{
+				Window:  time.Unix(0, int64(binary.BigEndian.Uint64(k))),
+				Counter: counter,
+				TTL:     boltRecordExpiresAt(v).Sub(now),
+			})
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Expire removes every window of this storage whose TTL has elapsed, returning how many were removed. Stale windows
+// are also dropped on every Inc, so calling this method is only needed to reclaim space of idle limiters.
+func (s FixedWindowBoltStorage) Expire(ctx context.Context) (removed int, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	now := s.opts.Clock.Now()
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := s.lookupBucket(tx)
+		if b == nil {
+			return nil
+		}
+
+		var err error
+		removed, err = expireBoltWindows(b, now)
+		return err
+	})
+
+	return
+}
+
+func (s FixedWindowBoltStorage) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	root, err := tx.CreateBucketIfNotExists(boltRootBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return root.CreateBucketIfNotExists(s.key)
+}
+
+func (s FixedWindowBoltStorage) lookupBucket(tx *bolt.Tx) *bolt.Bucket {
+	root := tx.Bucket(boltRootBucket)
+	if root == nil {
+		return nil
+	}
+
+	return root.Bucket(s.key)
+}
+
+func expireBoltWindows(b *bolt.Bucket, now time.Time) (int, error) {
+	var stale [][]byte
+
+	err := b.ForEach(func(k, v []byte) error {
+		if _, alive := decodeBoltRecord(v, now); !alive {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, k := range stale {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), nil
+}
+
+// boltWindowKey encodes windows big endian so that the keys are sorted by time
+func boltWindowKey(window time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(window.UnixNano()))
+	return k
+}
+
+func encodeBoltRecord(counter int64, expiresAt time.Time) []byte {
+	v := make([]byte, boltRecordLen)
+	binary.BigEndian.PutUint64(v[:8], uint64(counter))
+	binary.BigEndian.PutUint64(v[8:], uint64(expiresAt.UnixNano()))
+	return v
+}
+
+// decodeBoltRecord returns the counter stored in v and whether it is still alive at the given time
+func decodeBoltRecord(v []byte, now time.Time) (int64, bool) {
+	if len(v) != boltRecordLen {
+		return 0, false
+	}
+
+	if !boltRecordExpiresAt(v).After(now) {
+		return 0, false
+	}
+
+	return int64(binary.BigEndian.Uint64(v[:8])), true
+}
+
+// boltRecordExpiresAt returns when the valid record v expires
+func boltRecordExpiresAt(v []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v[8:])))
+}
+
+// NewFixedWindowBoltStorage returns a new instance of FixedWindowBoltStorage on top of an already opened bbolt
+// database. Closing the database is up to the caller.
+func NewFixedWindowBoltStorage(
+	db *bolt.DB,
+	opts FixedWindowBoltStorageOpts,
+) (FixedWindowBoltStorage, error) {
+	if opts.Key == "" {
+		return FixedWindowBoltStorage{}, ErrEmptyKey
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	return FixedWindowBoltStorage{
+		db:   db,
+		opts: opts,
+		key:  []byte(opts.Key),
+	}, nil
+}
@@ -0,0 +1,162 @@
+package pacemaker_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openBolt(t *testing.T, path string) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("cannot open bolt database: %v", err)
+	}
+
+	return db
+}
+
+func newBoltStorage(
+	t *testing.T,
+	db *bolt.DB,
+	key string,
+	clock *pacemaker.TestClock,
+) pacemaker.FixedWindowBoltStorage {
+	t.Helper()
+
+	s, err := pacemaker.NewFixedWindowBoltStorage(db, pacemaker.FixedWindowBoltStorageOpts{
+		Key:   key,
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	return s
+}
+
+func TestFixedWindowBoltStorage_Conformance(t *testing.T) {
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			db := openBolt(t, filepath.Join(t.TempDir(), "pacemaker.db"))
+			t.Cleanup(func() { _ = db.Close() })
+			return newBoltStorage(t, db, "conformance", clock)
+		},
+		Expires:  true,
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+func TestFixedWindowBoltStorage_EmptyKey(t *testing.T) {
+	db := openBolt(t, filepath.Join(t.TempDir(), "pacemaker.db"))
+	defer db.Close()
+
+	_, err := pacemaker.NewFixedWindowBoltStorage(db, pacemaker.FixedWindowBoltStorageOpts{})
+	if err != pacemaker.ErrEmptyKey {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrEmptyKey, err)
+	}
+}
+
+func TestFixedWindowBoltStorage_ShouldMaintainStateAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "pacemaker.db")
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+	args := pacemaker.FixedWindowArgs{
+		Capacity: 10,
+		Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+		Clock:    clock,
+	}
+
+	db := openBolt(t, path)
+	args.DB = newBoltStorage(t, db, "restarts", clock)
+
+	for i := 0; i < 3; i++ {
+		if _, err := pacemaker.NewFixedWindowRateLimiter(args).Try(ctx); err != nil {
+			t.Fatalf("unexpected error on try, want none, have %v", err)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("cannot close bolt database: %v", err)
+	}
+
+	clock.Forward(time.Second * 10)
+
+	db = openBolt(t, path)
+	defer db.Close()
+	args.DB = newBoltStorage(t, db, "restarts", clock)
+
+	limiter := pacemaker.NewFixedWindowRateLimiter(args)
+
+	res, err := limiter.Try(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on try, want none, have %v", err)
+	}
+
+	if res.FreeSlots != 6 {
+		t.Errorf("unexpected free slots, want 6, have %d", res.FreeSlots)
+	}
+}
+
+func TestFixedWindowBoltStorage_KeysShareFile(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	db := openBolt(t, filepath.Join(t.TempDir(), "pacemaker.db"))
+	defer db.Close()
+
+	orders := newBoltStorage(t, db, "orders", clock)
+	weight := newBoltStorage(t, db, "weight", clock)
+
+	window := clock.Now().Truncate(time.Minute)
+	args := pacemaker.FixedWindowIncArgs{Window: window, TTL: time.Minute, Tokens: 5, Capacity: 10}
+
+	if _, err := orders.Inc(ctx, args); err != nil {
+		t.Fatalf("unexpected error on inc, want none, have %v", err)
+	}
+
+	if c, _ := weight.Get(ctx, window); c != 0 {
+		t.Errorf("unexpected counter on other key, want 0, have %d", c)
+	}
+
+	if c, _ := orders.Get(ctx, window); c != 5 {
+		t.Errorf("unexpected counter, want 5, have %d", c)
+	}
+}
+
+func TestFixedWindowBoltStorage_Expire(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	db := openBolt(t, filepath.Join(t.TempDir(), "pacemaker.db"))
+	defer db.Close()
+
+	s := newBoltStorage(t, db, "expire", clock)
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Inc(ctx, pacemaker.FixedWindowIncArgs{
+			Window:   clock.Now(),
+			TTL:      time.Second * 5,
+			Tokens:   1,
+			Capacity: 10,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on inc, want none, have %v", err)
+		}
+		clock.Forward(time.Second * 2)
+	}
+
+	removed, err := s.Expire(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on expire, want none, have %v", err)
+	}
+
+	if removed != 1 {
+		t.Errorf("unexpected removed windows, want 1, have %d", removed)
+	}
+}
@@ -0,0 +1,256 @@
+package pacemaker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// regionCounter reads the counter a region holds for a window
+	regionCounter interface {
+		Get(ctx context.Context, window time.Time) (int64, error)
+	}
+
+	// CRDTPeer is a region whose counters are merged by FixedWindowCRDTStorage
+	CRDTPeer struct {
+		Region string
+		// Storage reads the counters of the region. Typically, a storage with the same settings as the Local
+		// storage of the region, connected to its redis.
+		Storage regionCounter
+	}
+
+	FixedWindowCRDTStorageOpts struct {
+		// Local holds the counter of this region, e.g. a FixedWindowRedisStorage on the redis of the region
+		Local fixedTruncatedWindowStorage
+		// Peers are the other regions
+		Peers []CRDTPeer
+		// MaxLocalShare is the fraction of the capacity of every window this region can admit on its own,
+		// in (0, 1]. As remote counters are only known after merging, regions may admit more than the capacity
+		// altogether, but never more than MaxLocalShare of it each. E.g. two regions with a share of 0.6 never
+		// over-admit more than 20% of the capacity. Defaults to 1, which bounds nothing.
+		MaxLocalShare float64
+		// Clock is used to forget windows once their TTL has elapsed. Defaults to RealClock.
+		Clock clock
+	}
+
+	// FixedWindowCRDTStorage limits a rate shared by several regions without cross-region calls per request.
+	// Every window is a G-counter: each region increases its own counter in its Local storage, and periodically
+	// merges the last known counters of its peers, keeping the greatest value seen for each of them. Requests are
+	// admitted on the sum of the local counter and the remote ones. Windows must be the same across regions, so
+	// use it with FixedTruncatedWindowRateLimiter, whose windows are aligned to the clock.
+	//
+	// Merges run on demand with Merge, typically every second or so with RunMerger. Only windows this region
+	// accessed are merged, until their TTL elapses.
+	FixedWindowCRDTStorage struct {
+		opts FixedWindowCRDTStorageOpts
+
+		mu sync.Mutex
+		// windows are keyed by their unix nanoseconds, as equal times may differ in location
+		windows map[int64]*crdtWindow
+	}
+
+	crdtWindow struct {
+		window    time.Time
+		expiresAt time.Time
+		remote    map[string]int64
+	}
+)
+
+// Inc will increase, if there is room to, the local counter for the bucket specified by window argument. The
+// returned counter includes the last known counters of the peers. When only the share of this region is exhausted,
+// it is reported over the capacity all the same.
+//
+// The share and the room left by the peers are enforced here rather than by the Local storage, which is only ever
+// given the whole capacity: storages capping their counter at a lower capacity would decrease it otherwise, while
+// a G-counter must only grow.
+func (s *FixedWindowCRDTStorage) Inc(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	remote := s.track(args.Window, s.opts.Clock.Now().Add(args.TTL))
+
+	localCapacity := args.Capacity - remote
+	if share := int64(math.Floor(s.opts.MaxLocalShare * float64(args.Capacity))); share < localCapacity {
+		localCapacity = share
+	}
+
+	// exceeded reports the local counter c over the capacity, even if only the local one is exceeded
+	exceeded := func(c int64) int64 {
+		if c+remote <= args.Capacity {
+			return args.Capacity + args.Tokens
+		}
+		return c + remote
+	}
+
+	counter, err := s.opts.Local.Get(ctx, args.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	if counter+args.Tokens > localCapacity {
+		return exceeded(counter + args.Tokens), nil
+	}
+
+	counter, err = s.opts.Local.Inc(ctx, args)
+	if err != nil {
+		return 0, err
+	}
+
+	// Other processes of this region may have increased the local counter in between
+	if counter > localCapacity {
+		return exceeded(counter), nil
+	}
+
+	return counter + remote, nil
+}
+
+// Get returns the local counter of window plus the last known counters of the peers
+func (s *FixedWindowCRDTStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (int64, error) {
+	remote := s.track(window, time.Time{})
+
+	counter, err := s.opts.Local.Get(ctx, window)
+	if err != nil {
+		return 0, err
+	}
+
+	return counter + remote, nil
+}
+
+// Remote returns the last known counters of the peers for window, by region name
+func (s *FixedWindowCRDTStorage) Remote(window time.Time) map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make(map[string]int64, len(s.opts.Peers))
+
+	if w, ok := s.windows[window.UnixNano()]; ok {
+		for region, counter := range w.remote {
+			res[region] = counter
+		}
+	}
+
+	return res
+}
+
+// Merge reads the counters of the peers for the windows being tracked. Peers failing are skipped, keeping their
+// last known counters, and the first error is returned once every peer has been tried.
+func (s *FixedWindowCRDTStorage) Merge(ctx context.Context) error {
+	s.mu.Lock()
+	windows := make([]time.Time, 0, len(s.windows))
+	for _, w := range s.windows {
+		windows = append(windows, w.window)
+	}
+	s.mu.Unlock()
+
+	var firstErr error
+
+	for _, peer := range s.opts.Peers {
+		for _, window := range windows {
+			counter, err := peer.Storage.Get(ctx, window)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("merging region %s: %w", peer.Region, err)
+				}
+				break
+			}
+
+			s.mu.Lock()
+			if w, ok := s.windows[window.UnixNano()]; ok && counter > w.remote[peer.Region] {
+				// G-counters only grow, so stale reads never lower what is known
+				w.remote[peer.Region] = counter
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	s.forget(windows, s.opts.Clock.Now())
+
+	return firstErr
+}
+
+// RunMerger calls Merge every interval until the context is done. Errors are handed to onErr, which may be nil.
+// Typically run in its own goroutine.
+func (s *FixedWindowCRDTStorage) RunMerger(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Merge(ctx); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// track registers window to be merged until expiresAt, returning the sum of its remote counters
+func (s *FixedWindowCRDTStorage) track(window time.Time, expiresAt time.Time) (remote int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[window.UnixNano()]
+	if !ok {
+		w = &crdtWindow{window: window, remote: make(map[string]int64, len(s.opts.Peers))}
+		s.windows[window.UnixNano()] = w
+	}
+
+	if expiresAt.After(w.expiresAt) {
+		w.expiresAt = expiresAt
+	}
+
+	for _, counter := range w.remote {
+		remote += counter
+	}
+
+	return
+}
+
+// forget stops tracking the windows just merged whose TTL has elapsed. Windows only read, whose TTL is unknown,
+// are forgotten once a later window is tracked.
+func (s *FixedWindowCRDTStorage) forget(windows []time.Time, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest time.Time
+	for _, w := range s.windows {
+		if w.window.After(latest) {
+			latest = w.window
+		}
+	}
+
+	for _, window := range windows {
+		w, ok := s.windows[window.UnixNano()]
+		if !ok {
+			continue
+		}
+
+		if (w.expiresAt.IsZero() && w.window.Before(latest)) || (!w.expiresAt.IsZero() && !w.expiresAt.After(now)) {
+			delete(s.windows, window.UnixNano())
+		}
+	}
+}
+
+// NewFixedWindowCRDTStorage returns a new instance of FixedWindowCRDTStorage
+func NewFixedWindowCRDTStorage(opts FixedWindowCRDTStorageOpts) *FixedWindowCRDTStorage {
+	if opts.MaxLocalShare <= 0 || opts.MaxLocalShare > 1 {
+		opts.MaxLocalShare = 1
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	return &FixedWindowCRDTStorage{
+		opts:    opts,
+		windows: make(map[int64]*crdtWindow),
+	}
+}
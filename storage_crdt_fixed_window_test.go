@@ -0,0 +1,238 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestFixedWindowCRDTStorage_Conformance(t *testing.T) {
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			return pacemaker.NewFixedWindowCRDTStorage(pacemaker.FixedWindowCRDTStorageOpts{
+				Local: pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock}).For("crdt"),
+				Clock: clock,
+			})
+		},
+		Expires:  true,
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+// newCRDTRegions returns the storages of two regions, each one keeping its counters in its own redis
+func newCRDTRegions(t *testing.T, share float64) (a, b *pacemaker.FixedWindowCRDTStorage) {
+	redisA, redisB := pacemakertest.NewRedis(t), pacemakertest.NewRedis(t)
+
+	opts := pacemaker.FixedWindowRedisStorageOpts{Prefix: "crdt"}
+	localA := pacemaker.NewFixedWindowRedisStorage(redisA.Client, opts)
+	localB := pacemaker.NewFixedWindowRedisStorage(redisB.Client, opts)
+
+	a = pacemaker.NewFixedWindowCRDTStorage(pacemaker.FixedWindowCRDTStorageOpts{
+		Local:         localA,
+		Peers:         []pacemaker.CRDTPeer{{Region: "b", Storage: localB}},
+		MaxLocalShare: share,
+	})
+
+	b = pacemaker.NewFixedWindowCRDTStorage(pacemaker.FixedWindowCRDTStorageOpts{
+		Local:         localB,
+		Peers:         []pacemaker.CRDTPeer{{Region: "a", Storage: localA}},
+		MaxLocalShare: share,
+	})
+
+	return
+}
+
+func TestFixedWindowCRDTStorage_Regions(t *testing.T) {
+	tests := []struct {
+		name      string
+		share     float64
+		merge     bool
+		expectedA int
+		expectedB int
+	}{
+		{
+			name:      "regions over-admit without merging",
+			share:     1,
+			merge:     false,
+			expectedA: 6,
+			expectedB: 10,
+		},
+		{
+			name:      "merged counters are shared",
+			share:     1,
+			merge:     true,
+			expectedA: 6,
+			expectedB: 4,
+		},
+		{
+			name:      "local share bounds over-admission",
+			share:     0.5,
+			merge:     false,
+			expectedA: 5,
+			expectedB: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			a, b := newCRDTRegions(t, test.share)
+
+			args := pacemaker.FixedWindowIncArgs{
+				Window:   time.Now().Truncate(time.Minute),
+				TTL:      time.Minute,
+				Tokens:   1,
+				Capacity: 10,
+			}
+
+			admit := func(s *pacemaker.FixedWindowCRDTStorage, n int) (admitted int) {
+				for i := 0; i < n; i++ {
+					c, err := s.Inc(ctx, args)
+					if err != nil {
+						t.Fatalf("unexpected error, want none, have %v", err)
+					}
+					if c <= args.Capacity {
+						admitted++
+					}
+				}
+				return
+			}
+
+			if admitted := admit(a, 6); admitted != test.expectedA {
+				t.Errorf("unexpected admitted requests on region a, want %d, have %d", test.expectedA, admitted)
+			}
+
+			if test.merge {
+				// Region b learns about its windows on first access
+				_, _ = b.Get(ctx, args.Window)
+
+				if err := b.Merge(ctx); err != nil {
+					t.Fatalf("unexpected error, want none, have %v", err)
+				}
+			}
+
+			if admitted := admit(b, 20); admitted != test.expectedB {
+				t.Errorf("unexpected admitted requests on region b, want %d, have %d", test.expectedB, admitted)
+			}
+		})
+	}
+}
+
+func TestFixedWindowCRDTStorage_MemoryReplicas(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	// Memory storages cap their counter at the capacity they are given rather than rejecting
+	localA := pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)
+	localB := pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)
+
+	a := pacemaker.NewFixedWindowCRDTStorage(pacemaker.FixedWindowCRDTStorageOpts{
+		Local: localA,
+		Peers: []pacemaker.CRDTPeer{{Region: "b", Storage: localB}},
+		Clock: clock,
+	})
+
+	b := pacemaker.NewFixedWindowCRDTStorage(pacemaker.FixedWindowCRDTStorageOpts{
+		Local: localB,
+		Peers: []pacemaker.CRDTPeer{{Region: "a", Storage: localA}},
+		Clock: clock,
+	})
+
+	args := pacemaker.FixedWindowIncArgs{Window: clock.Now(), TTL: time.Minute, Tokens: 1, Capacity: 10}
+
+	inc := func(s *pacemaker.FixedWindowCRDTStorage, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := s.Inc(ctx, args); err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+		}
+	}
+
+	inc(b, 2)
+	inc(a, 9)
+
+	if err := b.Merge(ctx); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	c, err := b.Inc(ctx, args)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if c <= args.Capacity {
+		t.Errorf("unexpected counter, want over %d, have %d", args.Capacity, c)
+	}
+
+	if local, _ := localB.Get(ctx, args.Window); local != 2 {
+		t.Errorf("unexpected local counter of region b, want 2, have %d", local)
+	}
+
+	if c, _ := b.Get(ctx, args.Window); c != 11 {
+		t.Errorf("unexpected counter of region b, want 11, have %d", c)
+	}
+}
+
+// scriptedPeer returns reads in order, failing once exhausted
+type scriptedPeer struct {
+	reads []int64
+}
+
+var errPeerDown = errors.New("peer down")
+
+func (p *scriptedPeer) Get(context.Context, time.Time) (int64, error) {
+	if len(p.reads) == 0 {
+		return 0, errPeerDown
+	}
+
+	c := p.reads[0]
+	p.reads = p.reads[1:]
+
+	return c, nil
+}
+
+func TestFixedWindowCRDTStorage_MergeKeepsKnownCounters(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	window := clock.Now()
+
+	s := pacemaker.NewFixedWindowCRDTStorage(pacemaker.FixedWindowCRDTStorageOpts{
+		Local: pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock}).For("local"),
+		Peers: []pacemaker.CRDTPeer{{Region: "remote", Storage: &scriptedPeer{reads: []int64{3, 1}}}},
+		Clock: clock,
+	})
+
+	_, _ = s.Inc(ctx, pacemaker.FixedWindowIncArgs{Window: window, TTL: time.Minute, Tokens: 1, Capacity: 10})
+
+	steps := []struct {
+		name            string
+		expectedErr     error
+		expectedCounter int64
+	}{
+		{name: "merge", expectedErr: nil, expectedCounter: 4},
+		{name: "stale read", expectedErr: nil, expectedCounter: 4},
+		{name: "peer down", expectedErr: errPeerDown, expectedCounter: 4},
+	}
+
+	for _, step := range steps {
+		if err := s.Merge(ctx); !errors.Is(err, step.expectedErr) {
+			t.Errorf("%s: unexpected error, want %v, have %v", step.name, step.expectedErr, err)
+		}
+
+		if c, _ := s.Get(ctx, window); c != step.expectedCounter {
+			t.Errorf("%s: unexpected counter, want %d, have %d", step.name, step.expectedCounter, c)
+		}
+	}
+
+	clock.Forward(time.Minute)
+
+	_ = s.Merge(ctx)
+
+	if remote := s.Remote(window); len(remote) != 0 {
+		t.Errorf("expected expired window to be forgotten, have remote counters %v", remote)
+	}
+}
@@ -0,0 +1,437 @@
+package pacemaker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	FixedWindowGossipStorageOpts struct {
+		// ID identifies this node among its peers, so it must be unique. Defaults to the hostname followed by
+		// random bytes, as nodes often listen at the same address on different hosts, e.g. ":7946".
+		ID string
+		// Addr is the UDP address to listen at for the usage of peers, e.g. ":7946". Defaults to "127.0.0.1:0".
+		Addr string
+		// Peers are the UDP addresses of the other nodes
+		Peers []string
+		// Interval is how often Run gossips. Defaults to 100ms.
+		Interval time.Duration
+		// PeerTimeout is how long a peer can stay silent before its share is reserved in full. Defaults to five
+		// times Interval.
+		PeerTimeout time.Duration
+		// Clock is used to expire windows and detect silent peers. Defaults to RealClock.
+		Clock clock
+	}
+
+	// FixedWindowGossipStorage shares a rate among several instances of a program without a central storage.
+	// Every node admits requests up to its share of the capacity and gossips its usage to its peers over UDP.
+	// Shares are rebalanced by demand: a node is entitled to the fraction of the capacity matching the fraction
+	// of tokens it was requested in the window, so idle nodes yield their share to busy ones. Peers not heard from
+	// for PeerTimeout, or yet, are assumed to be as busy as this node, so that their share is kept for them until
+	// they gossip. Windows must be the same across nodes, so use it with FixedTruncatedWindowRateLimiter, whose
+	// windows are aligned to the clock.
+	//
+	// As shares are computed from the last known usage of peers, nodes may admit more than the capacity altogether
+	// while demand is shifting between them. Gossip often, see Run, to keep the error small.
+	FixedWindowGossipStorage struct {
+		opts FixedWindowGossipStorageOpts
+
+		conn *net.UDPConn
+		wg   sync.WaitGroup
+
+		mu     sync.Mutex
+		peers  []*net.UDPAddr
+		local  map[int64]*gossipWindow
+		remote map[string]*gossipPeer
+	}
+
+	gossipWindow struct {
+		used      int64
+		demand    int64
+		expiresAt time.Time
+	}
+
+	gossipPeer struct {
+		lastHeard time.Time
+		windows   map[int64]*gossipWindow
+	}
+
+	// gossipMessage is the datagram nodes send to their peers
+	gossipMessage struct {
+		ID      string                `json:"id"`
+		Windows []gossipMessageWindow `json:"windows"`
+	}
+
+	gossipMessageWindow struct {
+		Window int64         `json:"window"`
+		Used   int64         `json:"used"`
+		Demand int64         `json:"demand"`
+		TTL    time.Duration `json:"ttl"`
+	}
+)
+
+const (
+	defaultGossipAddr     = "127.0.0.1:0"
+	defaultGossipInterval = 100 * time.Millisecond
+	gossipMaxDatagram     = 64 * 1024
+)
+
+// Inc will increase, if there is room to, the local counter for the bucket specified by window argument. The
+// returned counter includes the last known usage of the peers. When only the share of this node is exhausted,
+// it is reported over the capacity all the same.
+func (s *FixedWindowGossipStorage) Inc(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args.Window.UnixNano()
+
+	w := s.local[key]
+	if w == nil || !w.expiresAt.After(now) {
+		w = &gossipWindow{}
+		s.local[key] = w
+	}
+
+	w.demand += args.Tokens
+	w.expiresAt = now.Add(args.TTL)
+
+	remoteUsed, remoteDemand, silent := s.remoteUsage(key, now)
+
+	// Silent peers are assumed to demand as much as this node
+	totalDemand := w.demand + remoteDemand + int64(silent)*w.demand
+
+	share := args.Capacity
+	if totalDemand > 0 {
+		share = int64(float64(args.Capacity) * float64(w.demand) / float64(totalDemand))
+	}
+
+	counter := w.used + remoteUsed + args.Tokens
+
+	if counter > args.Capacity {
+		return counter, nil
+	}
+
+	if w.used+args.Tokens > share {
+		return args.Capacity + args.Tokens, nil
+	}
+
+	w.used += args.Tokens
+
+	return counter, nil
+}
+
+// Get returns the local counter of window plus the last known usage of the peers
+func (s *FixedWindowGossipStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := window.UnixNano()
+
+	var counter int64
+	if w := s.local[key]; w != nil && w.expiresAt.After(now) {
+		counter = w.used
+	}
+
+	remoteUsed, _, _ := s.remoteUsage(key, now)
+
+	return counter + remoteUsed, nil
+}
+
+// Addr returns the address this node listens at
+func (s *FixedWindowGossipStorage) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// ID returns the identifier this node gossips with
+func (s *FixedWindowGossipStorage) ID() string {
+	return s.opts.ID
+}
+
+// SetPeers replaces the UDP addresses of the other nodes
+func (s *FixedWindowGossipStorage) SetPeers(addrs []string) error {
+	peers, err := resolveGossipPeers(addrs)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.peers = peers
+	s.opts.Peers = addrs
+
+	return nil
+}
+
+// Gossip sends the usage of this node to its peers, and drops the windows whose TTL elapsed. It is also the
+// heartbeat of the node, so it is sent even if there is no usage to report.
+func (s *FixedWindowGossipStorage) Gossip(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+
+	s.expire(now)
+
+	msg := gossipMessage{ID: s.opts.ID, Windows: make([]gossipMessageWindow, 0, len(s.local))}
+	for key, w := range s.local {
+		msg.Windows = append(msg.Windows, gossipMessageWindow{
+			Window: key,
+			Used:   w.used,
+			Demand: w.demand,
+			TTL:    w.expiresAt.Sub(now),
+		})
+	}
+
+	peers := s.peers
+
+	s.mu.Unlock()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+
+	for _, peer := range peers {
+		if _, err := s.conn.WriteToUDP(data, peer); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Run gossips every Interval until the context is done. Errors are handed to onErr, which may be nil. Typically
+// run in its own goroutine.
+func (s *FixedWindowGossipStorage) Run(ctx context.Context, onErr func(error)) {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Gossip(ctx); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// Close stops listening to peers
+func (s *FixedWindowGossipStorage) Close() error {
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *FixedWindowGossipStorage) listen() {
+	defer s.wg.Done()
+
+	buf := make([]byte, gossipMaxDatagram)
+
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		var msg gossipMessage
+		if err := json.Unmarshal(buf[:n], &msg); err != nil || msg.ID == s.opts.ID {
+			continue
+		}
+
+		s.receive(msg)
+	}
+}
+
+func (s *FixedWindowGossipStorage) receive(msg gossipMessage) {
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.remote[msg.ID]
+	if p == nil {
+		p = &gossipPeer{windows: make(map[int64]*gossipWindow)}
+		s.remote[msg.ID] = p
+	}
+
+	p.lastHeard = now
+
+	for _, mw := range msg.Windows {
+		w := p.windows[mw.Window]
+		if w == nil {
+			w = &gossipWindow{}
+			p.windows[mw.Window] = w
+		}
+
+		// Datagrams may be reordered, but usage only grows within a window
+		w.used = max(w.used, mw.Used)
+		w.demand = max(w.demand, mw.Demand)
+		w.expiresAt = now.Add(mw.TTL)
+	}
+}
+
+// remoteUsage sums the usage of the peers in the window. Demand is only summed for peers heard from recently,
+// the others being counted as silent. Must be called with the lock held.
+func (s *FixedWindowGossipStorage) remoteUsage(key int64, now time.Time) (used, demand int64, silent int) {
+	alive := 0
+
+	for _, p := range s.remote {
+		isAlive := now.Sub(p.lastHeard) < s.opts.PeerTimeout
+		if isAlive {
+			alive++
+		}
+
+		w := p.windows[key]
+		if w == nil || !w.expiresAt.After(now) {
+			continue
+		}
+
+		used += w.used
+		if isAlive {
+			demand += w.demand
+		}
+	}
+
+	if silent = len(s.peers) - alive; silent < 0 {
+		silent = 0
+	}
+
+	return
+}
+
+// expire drops the windows whose TTL elapsed. Must be called with the lock held.
+func (s *FixedWindowGossipStorage) expire(now time.Time) {
+	for key, w := range s.local {
+		if !w.expiresAt.After(now) {
+			delete(s.local, key)
+		}
+	}
+
+	for _, p := range s.remote {
+		for key, w := range p.windows {
+			if !w.expiresAt.After(now) {
+				delete(p.windows, key)
+			}
+		}
+	}
+}
+
+// newGossipID returns an identifier unique to this node, even among nodes of the same host and process
+func newGossipID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return host + "-" + hex.EncodeToString(b), nil
+}
+
+func resolveGossipPeers(addrs []string) ([]*net.UDPAddr, error) {
+	peers := make([]*net.UDPAddr, 0, len(addrs))
+
+	for _, addr := range addrs {
+		peer, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
+
+// NewFixedWindowGossipStorage returns a new instance of FixedWindowGossipStorage listening to its peers. Call
+// Run to gossip its usage, and Close to stop listening.
+func NewFixedWindowGossipStorage(opts FixedWindowGossipStorageOpts) (*FixedWindowGossipStorage, error) {
+	if opts.Addr == "" {
+		opts.Addr = defaultGossipAddr
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = defaultGossipInterval
+	}
+
+	if opts.PeerTimeout <= 0 {
+		opts.PeerTimeout = 5 * opts.Interval
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	peers, err := resolveGossipPeers(opts.Peers)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ID == "" {
+		if opts.ID, err = newGossipID(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	s := &FixedWindowGossipStorage{
+		opts:   opts,
+		conn:   conn,
+		peers:  peers,
+		local:  make(map[int64]*gossipWindow),
+		remote: make(map[string]*gossipPeer),
+	}
+
+	s.wg.Add(1)
+	go s.listen()
+
+	return s, nil
+}
@@ -0,0 +1,180 @@
+package pacemaker_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestFixedWindowGossipStorage_Conformance(t *testing.T) {
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			s, err := pacemaker.NewFixedWindowGossipStorage(pacemaker.FixedWindowGossipStorageOpts{Clock: clock})
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+			t.Cleanup(func() { _ = s.Close() })
+			return s
+		},
+		Expires:  true,
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+// newGossipNodes starts n nodes on localhost, each one peering with the others
+func newGossipNodes(t *testing.T, n int) []*pacemaker.FixedWindowGossipStorage {
+	nodes := make([]*pacemaker.FixedWindowGossipStorage, n)
+
+	for i := range nodes {
+		s, err := pacemaker.NewFixedWindowGossipStorage(pacemaker.FixedWindowGossipStorageOpts{
+			PeerTimeout: time.Minute,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+		nodes[i] = s
+	}
+
+	for i, s := range nodes {
+		var peers []string
+		for j, peer := range nodes {
+			if i != j {
+				peers = append(peers, peer.Addr().String())
+			}
+		}
+		if err := s.SetPeers(peers); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	return nodes
+}
+
+// gossip makes every node gossip, and waits until the usage of every node is known by node
+func gossip(t *testing.T, nodes []*pacemaker.FixedWindowGossipStorage, window time.Time, want int64) {
+	ctx := context.Background()
+
+	for _, s := range nodes {
+		if err := s.Gossip(ctx); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for _, s := range nodes {
+		for {
+			if c, _ := s.Get(ctx, window); c == want {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for nodes to gossip a usage of %d", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestFixedWindowGossipStorage_SharesCapacity(t *testing.T) {
+	ctx := context.Background()
+	nodes := newGossipNodes(t, 3)
+
+	args := pacemaker.FixedWindowIncArgs{
+		Window:   time.Now().Truncate(time.Minute),
+		TTL:      time.Minute,
+		Tokens:   1,
+		Capacity: 9,
+	}
+
+	admit := func(s *pacemaker.FixedWindowGossipStorage, n int) (admitted int64) {
+		for i := 0; i < n; i++ {
+			c, err := s.Inc(ctx, args)
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+			if c <= args.Capacity {
+				admitted++
+			}
+		}
+		return
+	}
+
+	// Before gossiping, peers are assumed to be as busy as each node
+	for i, s := range nodes[:2] {
+		if admitted := admit(s, 5); admitted != 3 {
+			t.Errorf("unexpected admitted requests on node %d, want 3, have %d", i, admitted)
+		}
+	}
+
+	gossip(t, nodes, args.Window, 6)
+
+	// The third node is idle, so its share is yielded to the busy ones, by demand
+	if admitted := admit(nodes[0], 5); admitted != 3 {
+		t.Errorf("unexpected admitted requests on node 0, want 3, have %d", admitted)
+	}
+
+	gossip(t, nodes, args.Window, 9)
+
+	for i, s := range nodes {
+		if admitted := admit(s, 1); admitted != 0 {
+			t.Errorf("unexpected admitted requests on node %d, want 0, have %d", i, admitted)
+		}
+	}
+}
+
+func TestFixedWindowGossipStorage_WildcardAddrs(t *testing.T) {
+	ctx := context.Background()
+	nodes := make([]*pacemaker.FixedWindowGossipStorage, 2)
+
+	for i := range nodes {
+		s, err := pacemaker.NewFixedWindowGossipStorage(pacemaker.FixedWindowGossipStorageOpts{
+			Addr:        "0.0.0.0:0",
+			PeerTimeout: time.Minute,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+		nodes[i] = s
+	}
+
+	if nodes[0].ID() == nodes[1].ID() {
+		t.Fatalf("unexpected ids, want unique, have %q on both nodes", nodes[0].ID())
+	}
+
+	// Nodes of different hosts listening at the same wildcard address would share ids derived from it
+	for i, s := range nodes {
+		if s.ID() == s.Addr().String() {
+			t.Errorf("unexpected id of node %d, want one not derived from its address, have %q", i, s.ID())
+		}
+	}
+
+	for i, s := range nodes {
+		peer := nodes[1-i].Addr().(*net.UDPAddr)
+		if err := s.SetPeers([]string{net.JoinHostPort("127.0.0.1", strconv.Itoa(peer.Port))}); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	args := pacemaker.FixedWindowIncArgs{
+		Window:   time.Now().Truncate(time.Minute),
+		TTL:      time.Minute,
+		Tokens:   1,
+		Capacity: 10,
+	}
+
+	for _, s := range nodes {
+		if _, err := s.Inc(ctx, args); err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+	}
+
+	// Nodes listening at the same kind of address still hear each other
+	gossip(t, nodes, args.Window, 2)
+}
@@ -0,0 +1,321 @@
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+type (
+	FixedWindowMemcachedStorageOpts struct {
+		Prefix string
+		// Exact makes Inc check the capacity with gets/cas loops instead of optimistic incr/decr. See
+		// FixedWindowMemcachedStorage for the trade-offs.
+		Exact bool
+		// MaxCASRetries bounds the retries of cas loops under contention. Defaults to 16.
+		MaxCASRetries int
+		// Clock is used to compute absolute expirations for windows longer than 30 days. Defaults to RealClock.
+		Clock clock
+	}
+
+	// FixedWindowMemcachedStorage keeps the rate limit state in memcached. Windows are created with `add` and an
+	// expiration aligned to the window TTL, then increased with `incr`. As memcached cannot check the capacity and
+	// increase a counter atomically, increments not fitting in the capacity are rolled back with `decr`. Otherwise,
+	// when Exact is set, counters are increased by gets/cas loops which never store a counter over the capacity.
+	//
+	// Compared to FixedWindowRedisStorage, whose LUA script checks and increases atomically:
+	//   - With incr/decr, a concurrent request may observe a counter transiently over the capacity while another
+	//     request is being rolled back, and thus be rejected even though it would have fit.
+	//   - With gets/cas, contention translates into retries and, once MaxCASRetries are exhausted,
+	//     ErrTooManyConflicts.
+	//   - Memcached evicts items under memory pressure, silently resetting counters, and does not replicate them:
+	//     losing a node loses the windows it held.
+	//   - Expirations have one second granularity, so windows may outlive their TTL by up to a second.
+	//   - The memcached client does not support contexts: they are only checked before each call.
+	FixedWindowMemcachedStorage struct {
+		cli *memcache.Client
+
+		opts FixedWindowMemcachedStorageOpts
+
+		keyGenerator func(time.Time) string
+
+		lastKey string
+	}
+)
+
+const (
+	defaultMaxCASRetries = 16
+	// memcachedRelativeLimit is the longest expiration memcached accepts in relative seconds
+	memcachedRelativeLimit = 60 * 60 * 24 * 30 * time.Second
+)
+
+// Inc will increase, if there is room to, the rate limiting counter for the bucket
+// specified by window argument.
+func (s FixedWindowMemcachedStorage) Inc(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if args.Tokens > args.Capacity {
+		counter, err := s.Get(ctx, args.Window)
+		return counter + args.Tokens, err
+	}
+
+	if s.opts.Exact {
+		return s.incCAS(ctx, args)
+	}
+
+	return s.incOptimistic(ctx, args)
+}
+
+func (s FixedWindowMemcachedStorage) incOptimistic(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	key := s.keyGenerator(args.Window)
+
+	for i := 0; i <= s.opts.MaxCASRetries; i++ {
+		n, err := s.cli.Increment(key, uint64(args.Tokens))
+
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			created, err := s.create(ctx, key, args)
+			if err != nil {
+				return 0, err
+			}
+			if created {
+				return args.Tokens, nil
+			}
+			// Somebody else created the window in the meantime
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		counter := int64(n)
+
+		if counter > args.Capacity {
+			if _, err = s.cli.Decrement(key, uint64(args.Tokens)); err != nil &&
+				!errors.Is(err, memcache.ErrCacheMiss) {
+				return 0, err
+			}
+		}
+
+		return counter, nil
+	}
+
+	return 0, ErrTooManyConflicts
+}
+
+func (s FixedWindowMemcachedStorage) incCAS(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	key := s.keyGenerator(args.Window)
+
+	for i := 0; i <= s.opts.MaxCASRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		item, err := s.cli.Get(key)
+
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			created, err := s.create(ctx, key, args)
+			if err != nil {
+				return 0, err
+			}
+			if created {
+				return args.Tokens, nil
+			}
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		counter, err := strconv.ParseInt(string(item.Value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		counter += args.Tokens
+
+		if counter > args.Capacity {
+			return counter, nil
+		}
+
+		item.Value = []byte(strconv.FormatInt(counter, 10))
+		item.Expiration = s.expiration(args.TTL)
+
+		err = s.cli.CompareAndSwap(item)
+
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrCacheMiss) {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return counter, nil
+	}
+
+	return 0, ErrTooManyConflicts
+}
+
+// create adds the window with the initial tokens, returning false if it already existed
+func (s FixedWindowMemcachedStorage) create(
+	ctx context.Context,
+	key string,
+	args FixedWindowIncArgs,
+) (bool, error) {
+	err := s.cli.Add(&memcache.Item{
+		Key:        key,
+		Value:      []byte(strconv.FormatInt(args.Tokens, 10)),
+		Expiration: s.expiration(args.TTL),
+	})
+
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, s.bumpLastWindow(ctx, args.Window, args.TTL)
+}
+
+// bumpLastWindow records window as the last one, unless a later one was already recorded
+func (s FixedWindowMemcachedStorage) bumpLastWindow(
+	ctx context.Context,
+	window time.Time,
+	ttl time.Duration,
+) error {
+	value := []byte(strconv.FormatInt(window.UnixNano(), 10))
+	expiration := s.expiration(ttl)
+
+	for i := 0; i <= s.opts.MaxCASRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		item, err := s.cli.Get(s.lastKey)
+
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			err = s.cli.Add(&memcache.Item{Key: s.lastKey, Value: value, Expiration: expiration})
+			if errors.Is(err, memcache.ErrNotStored) {
+				continue
+			}
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+
+		last, err := TimeFromNsStr(string(item.Value))
+		if err == nil && !last.Before(window) {
+			return nil
+		}
+
+		item.Value = value
+		item.Expiration = expiration
+
+		err = s.cli.CompareAndSwap(item)
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrCacheMiss) {
+			continue
+		}
+
+		return err
+	}
+
+	return ErrTooManyConflicts
+}
+
+func (s FixedWindowMemcachedStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (counter int64, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	var item *memcache.Item
+	item, err = s.cli.Get(s.keyGenerator(window))
+
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			err = nil
+		}
+		return
+	}
+
+	counter, err = strconv.ParseInt(string(item.Value), 10, 64)
+	return
+}
+
+func (s FixedWindowMemcachedStorage) LastWindow(ctx context.Context) (ts time.Time, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	var item *memcache.Item
+	item, err = s.cli.Get(s.lastKey)
+
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			err = ErrNoLastKey
+		}
+		return
+	}
+
+	ts, err = TimeFromNsStr(string(item.Value))
+	return
+}
+
+// expiration converts ttl to memcached expiration: relative seconds, rounded up, or an absolute unix timestamp
+// for TTLs longer than 30 days
+func (s FixedWindowMemcachedStorage) expiration(ttl time.Duration) int32 {
+	if ttl > memcachedRelativeLimit {
+		return int32(s.opts.Clock.Now().Add(ttl).Unix())
+	}
+
+	secs := (ttl + time.Second - 1) / time.Second
+	if secs < 1 {
+		secs = 1
+	}
+
+	return int32(secs)
+}
+
+func NewFixedWindowMemcachedStorage(
+	cli *memcache.Client,
+	opts FixedWindowMemcachedStorageOpts,
+) FixedWindowMemcachedStorage {
+	if opts.MaxCASRetries <= 0 {
+		opts.MaxCASRetries = defaultMaxCASRetries
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	return FixedWindowMemcachedStorage{
+		cli:     cli,
+		opts:    opts,
+		lastKey: opts.Prefix + keySep + "last",
+		keyGenerator: func(t time.Time) string {
+			return opts.Prefix + keySep + strconv.Itoa(int(t.UnixNano()))
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package pacemaker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestFixedWindowMemcachedStorage_Conformance(t *testing.T) {
+	modes := []struct {
+		name  string
+		exact bool
+	}{
+		{name: "incr", exact: false},
+		{name: "cas", exact: true},
+	}
+
+	for _, mode := range modes {
+		mode := mode
+		t.Run(mode.name, func(t *testing.T) {
+			var srv *pacemakertest.Memcached
+
+			pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+				New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+					srv = pacemakertest.NewMemcached(t)
+					return pacemaker.NewFixedWindowMemcachedStorage(
+						srv.Client,
+						pacemaker.FixedWindowMemcachedStorageOpts{
+							Prefix:        "pacemaker|conformance",
+							Exact:         mode.exact,
+							MaxCASRetries: 1000,
+						},
+					)
+				},
+				Advance: func(d time.Duration) {
+					srv.FastForward(d)
+				},
+				Expires:  true,
+				Overflow: pacemakertest.OverflowReject,
+			})
+		})
+	}
+}
+
+func TestFixedWindowMemcachedStorage_ExpirationAlignedToTTL(t *testing.T) {
+	ctx := context.Background()
+	srv := pacemakertest.NewMemcached(t)
+	storage := pacemaker.NewFixedWindowMemcachedStorage(
+		srv.Client,
+		pacemaker.FixedWindowMemcachedStorageOpts{Prefix: "pacemaker|ttl"},
+	)
+
+	window := time.Date(2022, 02, 05, 10, 23, 30, 0, time.UTC)
+
+	_, err := storage.Inc(ctx, pacemaker.FixedWindowIncArgs{
+		Window:   window,
+		TTL:      time.Millisecond * 6500,
+		Tokens:   1,
+		Capacity: 10,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on inc, want none, have %v", err)
+	}
+
+	key := "pacemaker|ttl|" + "1644056610000000000"
+	ttl := srv.Expiration(key).Sub(srv.Now())
+
+	if ttl <= time.Second*6 || ttl > time.Second*7 {
+		t.Errorf("unexpected expiration, want within (6s, 7s], have %v", ttl)
+	}
+}
+
+func TestFixedWindowMemcachedStorage_TooManyConflicts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := pacemakertest.NewMemcached(t)
+	storage := pacemaker.NewFixedWindowMemcachedStorage(
+		srv.Client,
+		pacemaker.FixedWindowMemcachedStorageOpts{Prefix: "pacemaker|conflicts", Exact: true, MaxCASRetries: 1},
+	)
+
+	args := pacemaker.FixedWindowIncArgs{
+		Window:   time.Date(2022, 02, 05, 10, 23, 30, 0, time.UTC),
+		TTL:      time.Minute,
+		Tokens:   1,
+		Capacity: 1 << 40,
+	}
+
+	if _, err := storage.Inc(ctx, args); err != nil {
+		t.Fatalf("unexpected error on inc, want none, have %v", err)
+	}
+
+	// Hammer the window from other goroutines so that cas loops keep on failing
+	for i := 0; i < 8; i++ {
+		go func() {
+			for ctx.Err() == nil {
+				_, _ = srv.Client.Increment("pacemaker|conflicts|1644056610000000000", 1)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		if _, err := storage.Inc(ctx, args); err == pacemaker.ErrTooManyConflicts {
+			return
+		}
+	}
+
+	t.Errorf("expected %v under contention", pacemaker.ErrTooManyConflicts)
+}
@@ -0,0 +1,527 @@
+package pacemaker
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+type (
+	// EvictionPolicy decides which key is evicted when a KeyedMemoryStorage is full
+	EvictionPolicy int
+
+	// EvictReason tells why a key was removed from a KeyedMemoryStorage
+	EvictReason int
+
+	KeyedMemoryStorageOpts struct {
+		// Shards is the number of lock stripes keys are spread across. It is rounded up to a power of two, and
+		// down to at most MaxKeys. Defaults to 64.
+		Shards int
+		// Clock is used to expire windows once their TTL has elapsed. Defaults to RealClock.
+		Clock clock
+		// MaxKeys bounds how many keys are held. Zero means unbounded. The bound is split among shards, whose
+		// quotas add up to MaxKeys, so keys may be evicted before MaxKeys are held if they hash unevenly.
+		MaxKeys int
+		// Eviction is the policy applied to make room for new keys once MaxKeys is reached. Defaults to EvictLRU.
+		Eviction EvictionPolicy
+		// OnEvict, if set, is called every time a key is removed, either because its window expired or to make
+		// room for new keys. It is called without holding any lock, so it is safe to use the storage from it.
+		OnEvict func(key string, reason EvictReason)
+	}
+
+	// KeyedMemoryStorage is an in-memory storage holding the rate limit state of many keys, e.g. one per user
+	// or IP address. Keys are spread across lock stripes, so that concurrent requests on different keys rarely
+	// contend. Each key holds its current window, which is dropped once its TTL elapses, either lazily when the
+	// key is accessed or proactively by Collect. Setting MaxKeys bounds its memory, which makes it safe to limit
+	// by attacker controlled keys such as IP addresses. Use For to obtain the storage of a single key to be handed
+	// to a rate limiter.
+	KeyedMemoryStorage struct {
+		shards   []*memoryShard
+		mask     uint64
+		seed     maphash.Seed
+		clock    clock
+		bounded  bool
+		eviction EvictionPolicy
+		onEvict  func(key string, reason EvictReason)
+	}
+
+	// KeyedMemoryStorageScope is the storage of a single key of a KeyedMemoryStorage, or of any other storage
+	// holding many keys
+	KeyedMemoryStorageScope struct {
+		storage keyedStorage
+		key     string
+	}
+
+	memoryShard struct {
+		mu      sync.Mutex
+		windows map[string]*memoryWindow
+		// lru holds the keys of the shard, most recently used first
+		lru *list.List
+		// maxKeys is the share of MaxKeys of the shard
+		maxKeys int
+	}
+
+	memoryWindow struct {
+		key       string
+		window    time.Time
+		counter   int64
+		expiresAt time.Time
+		elem      *list.Element
+	}
+
+	eviction struct {
+		key    string
+		reason EvictReason
+	}
+)
+
+const (
+	// EvictLRU evicts the least recently used key
+	EvictLRU EvictionPolicy = iota
+	// EvictOldestWindow evicts the key with the oldest window. It scans the whole shard on every eviction.
+	EvictOldestWindow
+)
+
+const (
+	// EvictExpired keys were removed because the TTL of their window elapsed
+	EvictExpired EvictReason = iota
+	// EvictCapacity keys were removed to make room for new keys
+	EvictCapacity
+)
+
+const (
+	defaultKeyedMemoryShards = 64
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	default:
+		return "unknown"
+	}
+}
+
+// For returns the storage of key, suitable for any fixed window rate limiter
+func (s *KeyedMemoryStorage) For(key string) KeyedMemoryStorageScope {
+	return KeyedMemoryStorageScope{storage: s, key: key}
+}
+
+// Inc will increase, if there is room to, the rate limiting counter of key for the bucket
+// specified by window argument.
+func (s *KeyedMemoryStorage) Inc(
+	ctx context.Context,
+	key string,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := s.clock.Now()
+	shard := s.shard(key)
+
+	var evicted []eviction
+
+	shard.mu.Lock()
+
+	w := shard.windows[key]
+
+	if w == nil {
+		evicted = s.makeRoom(shard, now)
+		w = &memoryWindow{key: key, window: args.Window}
+		w.elem = shard.lru.PushFront(w)
+		shard.windows[key] = w
+	} else {
+		shard.lru.MoveToFront(w.elem)
+		if !w.alive(now) || !w.window.Equal(args.Window) {
+			w.window = args.Window
+			w.counter = 0
+		}
+	}
+
+	counter := w.counter + args.Tokens
+
+	if counter <= args.Capacity {
+		w.counter = counter
+	}
+
+	w.expiresAt = now.Add(args.TTL)
+
+	shard.mu.Unlock()
+
+	s.notify(evicted)
+
+	return counter, nil
+}
+
+// Sync raises the counter of key for the bucket specified by window argument to the one of args, unless it is
+// higher already
+func (s *KeyedMemoryStorage) Sync(
+	ctx context.Context,
+	key string,
+	args FixedWindowSyncArgs,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := s.clock.Now()
+	shard := s.shard(key)
+
+	var evicted []eviction
+
+	shard.mu.Lock()
+
+	w := shard.windows[key]
+
+	if w == nil {
+		evicted = s.makeRoom(shard, now)
+		w = &memoryWindow{key: key, window: args.Window}
+		w.elem = shard.lru.PushFront(w)
+		shard.windows[key] = w
+	} else {
+		shard.lru.MoveToFront(w.elem)
+		if !w.alive(now) || !w.window.Equal(args.Window) {
+			w.window = args.Window
+			w.counter = 0
+		}
+	}
+
+	if args.Counter > w.counter || !w.alive(now) {
+		w.counter = max(w.counter, args.Counter)
+		w.expiresAt = now.Add(args.TTL)
+	}
+
+	counter := w.counter
+
+	shard.mu.Unlock()
+
+	s.notify(evicted)
+
+	return counter, nil
+}
+
+func (s *KeyedMemoryStorage) Get(
+	ctx context.Context,
+	key string,
+	window time.Time,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := s.clock.Now()
+	shard := s.shard(key)
+
+	shard.mu.Lock()
+
+	w := shard.windows[key]
+
+	if w == nil {
+		shard.mu.Unlock()
+		return 0, nil
+	}
+
+	if !w.alive(now) {
+		shard.remove(w)
+		shard.mu.Unlock()
+		s.notify([]eviction{{key: key, reason: EvictExpired}})
+		return 0, nil
+	}
+
+	shard.lru.MoveToFront(w.elem)
+
+	var counter int64
+	if w.window.Equal(window) {
+		counter = w.counter
+	}
+
+	shard.mu.Unlock()
+
+	return counter, nil
+}
+
+func (s *KeyedMemoryStorage) LastWindow(ctx context.Context, key string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	now := s.clock.Now()
+	shard := s.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	w := shard.windows[key]
+
+	if w == nil || !w.alive(now) {
+		return time.Time{}, ErrNoLastKey
+	}
+
+	return w.window, nil
+}
+
+// Collect removes every key whose window expired, returning how many were removed. Expired keys are otherwise
+// only reclaimed when accessed or evicted to make room for new ones.
+func (s *KeyedMemoryStorage) Collect(ctx context.Context) (int, error) {
+	removed := 0
+
+	for _, shard := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		now := s.clock.Now()
+
+		var evicted []eviction
+
+		shard.mu.Lock()
+		for _, w := range shard.windows {
+			if !w.alive(now) {
+				shard.remove(w)
+				evicted = append(evicted, eviction{key: w.key, reason: EvictExpired})
+			}
+		}
+		shard.mu.Unlock()
+
+		removed += len(evicted)
+		s.notify(evicted)
+	}
+
+	return removed, nil
+}
+
+// RunJanitor calls Collect every interval until the context is done. Typically run in its own goroutine.
+func (s *KeyedMemoryStorage) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = s.Collect(ctx)
+		}
+	}
+}
+
+// Snapshot returns the state of every key whose window has not expired, to be restored with Restore, e.g.
+// after a restart. Shards are visited one at a time, so concurrent increments may be partially captured.
+func (s *KeyedMemoryStorage) Snapshot(ctx context.Context) ([]byte, error) {
+	now := s.clock.Now()
+
+	var windows []snapshotWindow
+
+	for _, shard := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		shard.mu.Lock()
+		for _, w := range shard.windows {
+			if !w.alive(now) {
+				continue
+			}
+			windows = append(windows, snapshotWindow{
+				Key:     w.key,
+				Window:  w.window,
+				Counter: w.counter,
+				TTL:     w.expiresAt.Sub(now),
+			})
+		}
+		shard.mu.Unlock()
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Restore loads the keys of a snapshot taken by Snapshot, replacing the state of those already stored. Windows
+// whose TTL elapsed in the meantime are dropped. MaxKeys is enforced as if restored keys were new.
+func (s *KeyedMemoryStorage) Restore(ctx context.Context, data []byte) error {
+	now := s.clock.Now()
+
+	snap, err := decodeSnapshot(data, now)
+	if err != nil {
+		return err
+	}
+
+	for _, sw := range snap.Windows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		shard := s.shard(sw.Key)
+
+		var evicted []eviction
+
+		shard.mu.Lock()
+
+		w := shard.windows[sw.Key]
+
+		if w == nil {
+			evicted = s.makeRoom(shard, now)
+			w = &memoryWindow{key: sw.Key}
+			w.elem = shard.lru.PushFront(w)
+			shard.windows[sw.Key] = w
+		}
+
+		w.window = sw.Window
+		w.counter = sw.Counter
+		w.expiresAt = snap.expiresAt(sw)
+
+		shard.mu.Unlock()
+
+		s.notify(evicted)
+	}
+
+	return nil
+}
+
+// Len returns the number of keys stored, including those whose window expired but were not reclaimed yet
+func (s *KeyedMemoryStorage) Len() int {
+	n := 0
+
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		n += len(shard.windows)
+		shard.mu.Unlock()
+	}
+
+	return n
+}
+
+func (s *KeyedMemoryStorage) shard(key string) *memoryShard {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	_, _ = h.WriteString(key)
+
+	return s.shards[h.Sum64()&s.mask]
+}
+
+// makeRoom evicts keys until a new one fits in the shard. Must be called with the shard lock held.
+func (s *KeyedMemoryStorage) makeRoom(shard *memoryShard, now time.Time) (evicted []eviction) {
+	if !s.bounded {
+		return
+	}
+
+	for len(shard.windows) >= shard.maxKeys {
+		victim := s.victim(shard)
+
+		reason := EvictCapacity
+		if !victim.alive(now) {
+			reason = EvictExpired
+		}
+
+		shard.remove(victim)
+		evicted = append(evicted, eviction{key: victim.key, reason: reason})
+	}
+
+	return
+}
+
+// victim returns the key to evict according to the policy. Must be called with the shard lock held.
+func (s *KeyedMemoryStorage) victim(shard *memoryShard) *memoryWindow {
+	switch s.eviction {
+	case EvictOldestWindow:
+		var oldest *memoryWindow
+		for _, w := range shard.windows {
+			if oldest == nil || w.window.Before(oldest.window) {
+				oldest = w
+			}
+		}
+		return oldest
+	default:
+		return shard.lru.Back().Value.(*memoryWindow)
+	}
+}
+
+func (s *KeyedMemoryStorage) notify(evicted []eviction) {
+	if s.onEvict == nil {
+		return
+	}
+
+	for _, e := range evicted {
+		s.onEvict(e.key, e.reason)
+	}
+}
+
+// remove must be called with the lock held
+func (s *memoryShard) remove(w *memoryWindow) {
+	s.lru.Remove(w.elem)
+	delete(s.windows, w.key)
+}
+
+func (w *memoryWindow) alive(now time.Time) bool {
+	return w.expiresAt.After(now)
+}
+
+func (s KeyedMemoryStorageScope) Inc(ctx context.Context, args FixedWindowIncArgs) (int64, error) {
+	return s.storage.Inc(ctx, s.key, args)
+}
+
+func (s KeyedMemoryStorageScope) Get(ctx context.Context, window time.Time) (int64, error) {
+	return s.storage.Get(ctx, s.key, window)
+}
+
+func (s KeyedMemoryStorageScope) Sync(ctx context.Context, args FixedWindowSyncArgs) (int64, error) {
+	if syncer, ok := s.storage.(keyedCounterSyncer); ok {
+		return syncer.Sync(ctx, s.key, args)
+	}
+	return addCounter(ctx, s, args)
+}
+
+func (s KeyedMemoryStorageScope) LastWindow(ctx context.Context) (time.Time, error) {
+	return s.storage.LastWindow(ctx, s.key)
+}
+
+// NewKeyedMemoryStorage returns a new instance of KeyedMemoryStorage
+func NewKeyedMemoryStorage(opts KeyedMemoryStorageOpts) *KeyedMemoryStorage {
+	if opts.Shards <= 0 {
+		opts.Shards = defaultKeyedMemoryShards
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	n := 1
+	for n < opts.Shards {
+		n <<= 1
+	}
+
+	// every shard holds at least a key, so that their quotas add up to MaxKeys
+	for opts.MaxKeys > 0 && n > opts.MaxKeys {
+		n >>= 1
+	}
+
+	shards := make([]*memoryShard, n)
+	for i := range shards {
+		shards[i] = &memoryShard{
+			windows: make(map[string]*memoryWindow),
+			lru:     list.New(),
+		}
+
+		if opts.MaxKeys > 0 {
+			shards[i].maxKeys = opts.MaxKeys / n
+			if i < opts.MaxKeys%n {
+				shards[i].maxKeys++
+			}
+		}
+	}
+
+	return &KeyedMemoryStorage{
+		shards:   shards,
+		mask:     uint64(n - 1),
+		seed:     maphash.MakeSeed(),
+		clock:    opts.Clock,
+		bounded:  opts.MaxKeys > 0,
+		eviction: opts.Eviction,
+		onEvict:  opts.OnEvict,
+	}
+}
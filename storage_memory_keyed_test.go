@@ -0,0 +1,291 @@
+package pacemaker_test
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestKeyedMemoryStorage_Conformance(t *testing.T) {
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			return pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{
+				Clock: clock,
+			}).For("conformance")
+		},
+		Expires:  true,
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+func TestKeyedMemoryStorage_KeysAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock})
+
+	newLimiter := func(key string) *pacemaker.FixedWindowRateLimiter {
+		return pacemaker.NewFixedWindowRateLimiter(pacemaker.FixedWindowArgs{
+			Capacity: 1,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       storage.For(key),
+		})
+	}
+
+	alice, bob := newLimiter("alice"), newLimiter("bob")
+
+	if _, err := alice.Try(ctx); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if _, err := alice.Try(ctx); err != pacemaker.ErrRateLimitExceeded {
+		t.Fatalf("unexpected error, want %v, have %v", pacemaker.ErrRateLimitExceeded, err)
+	}
+
+	if _, err := bob.Try(ctx); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if n := storage.Len(); n != 2 {
+		t.Errorf("unexpected keys, want 2, have %d", n)
+	}
+}
+
+type evictions struct {
+	keys    []string
+	reasons []pacemaker.EvictReason
+}
+
+func (e *evictions) record(key string, reason pacemaker.EvictReason) {
+	e.keys = append(e.keys, key)
+	e.reasons = append(e.reasons, reason)
+}
+
+func TestKeyedMemoryStorage_Collect(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+	var evicted evictions
+
+	storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{
+		Clock:   clock,
+		OnEvict: evicted.record,
+	})
+
+	for i, ttl := range []time.Duration{time.Second, time.Second * 2, time.Second * 3} {
+		_, err := storage.Inc(ctx, "key-"+strconv.Itoa(i), pacemaker.FixedWindowIncArgs{
+			Window: clock.Now(), TTL: ttl, Tokens: 1, Capacity: 10,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on inc, want none, have %v", err)
+		}
+	}
+
+	clock.Forward(time.Second * 2)
+
+	removed, err := storage.Collect(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on collect, want none, have %v", err)
+	}
+
+	if removed != 2 || storage.Len() != 1 {
+		t.Errorf("unexpected collect, want 2 removed and 1 left, have %d and %d", removed, storage.Len())
+	}
+
+	for _, reason := range evicted.reasons {
+		if reason != pacemaker.EvictExpired {
+			t.Errorf("unexpected eviction reason, want %v, have %v", pacemaker.EvictExpired, reason)
+		}
+	}
+}
+
+func TestKeyedMemoryStorage_LazyExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+	var evicted evictions
+
+	storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{
+		Clock:   clock,
+		OnEvict: evicted.record,
+	})
+
+	window := clock.Now()
+	_, _ = storage.Inc(ctx, "key", pacemaker.FixedWindowIncArgs{
+		Window: window, TTL: time.Second, Tokens: 1, Capacity: 10,
+	})
+
+	clock.Forward(time.Second)
+
+	if c, _ := storage.Get(ctx, "key", window); c != 0 {
+		t.Errorf("unexpected counter, want 0, have %d", c)
+	}
+
+	if storage.Len() != 0 || len(evicted.keys) != 1 || evicted.reasons[0] != pacemaker.EvictExpired {
+		t.Errorf("expected key to be evicted on access, have %d keys and evictions %+v", storage.Len(), evicted)
+	}
+}
+
+func TestKeyedMemoryStorage_MaxKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		eviction pacemaker.EvictionPolicy
+		evicted  string
+	}{
+		{name: "lru", eviction: pacemaker.EvictLRU, evicted: "b"},
+		{name: "oldest window", eviction: pacemaker.EvictOldestWindow, evicted: "a"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+			var evicted evictions
+
+			storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{
+				Shards:   1,
+				MaxKeys:  2,
+				Eviction: test.eviction,
+				Clock:    clock,
+				OnEvict:  evicted.record,
+			})
+
+			inc := func(key string) {
+				_, err := storage.Inc(ctx, key, pacemaker.FixedWindowIncArgs{
+					Window: clock.Now(), TTL: time.Minute, Tokens: 1, Capacity: 10,
+				})
+				if err != nil {
+					t.Fatalf("unexpected error on inc, want none, have %v", err)
+				}
+				clock.Forward(time.Second)
+			}
+
+			// "a" holds the oldest window, while "b" is the least recently used
+			inc("a")
+			inc("b")
+			if _, err := storage.Get(ctx, "a", clock.Now()); err != nil {
+				t.Fatalf("unexpected error on get, want none, have %v", err)
+			}
+			inc("c")
+
+			if storage.Len() != 2 {
+				t.Errorf("unexpected keys, want 2, have %d", storage.Len())
+			}
+
+			if len(evicted.keys) != 1 || evicted.keys[0] != test.evicted ||
+				evicted.reasons[0] != pacemaker.EvictCapacity {
+				t.Errorf("unexpected evictions, want %q due to capacity, have %+v", test.evicted, evicted)
+			}
+		})
+	}
+}
+
+func TestKeyedMemoryStorage_MaxKeysAcrossShards(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+
+	for _, maxKeys := range []int{1, 10, 100} {
+		storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{MaxKeys: maxKeys, Clock: clock})
+
+		for i := 0; i < 1000; i++ {
+			_, err := storage.Inc(ctx, "key-"+strconv.Itoa(i), pacemaker.FixedWindowIncArgs{
+				Window: clock.Now(), TTL: time.Minute, Tokens: 1, Capacity: 10,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error on inc, want none, have %v", err)
+			}
+		}
+
+		if n := storage.Len(); n > maxKeys {
+			t.Errorf("unexpected keys, want at most %d, have %d", maxKeys, n)
+		}
+	}
+}
+
+// BenchmarkKeyedMemoryStorage_Inc increases the counters of many keys in parallel. Run it with several -cpu values,
+// e.g: go test -run ^$ -bench KeyedMemoryStorage -cpu 1,2,4,8, to compare how lock striping scales with cores against
+// a single lock.
+func BenchmarkKeyedMemoryStorage_Inc(b *testing.B) {
+	const keys = 1024
+
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key-" + strconv.Itoa(i)
+	}
+
+	args := pacemaker.FixedWindowIncArgs{
+		Window:   time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC),
+		TTL:      time.Hour,
+		Tokens:   1,
+		Capacity: 1 << 62,
+	}
+
+	for _, shards := range []int{1, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			storage := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Shards: shards})
+			ctx := context.Background()
+
+			var worker int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddInt64(&worker, 1)) * 7919
+				for pb.Next() {
+					_, _ = storage.Inc(ctx, names[i%keys], args)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestKeyedMemoryStorage_SnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 23, 0, time.UTC))
+	window := clock.Now()
+
+	src := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock})
+
+	_, _ = src.Inc(ctx, "short", pacemaker.FixedWindowIncArgs{
+		Window: window, TTL: time.Second, Tokens: 1, Capacity: 10,
+	})
+	_, _ = src.Inc(ctx, "long", pacemaker.FixedWindowIncArgs{
+		Window: window, TTL: time.Minute, Tokens: 4, Capacity: 10,
+	})
+
+	data, err := src.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	clock.Forward(time.Second)
+
+	dst := pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock})
+
+	if err := dst.Restore(ctx, data); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if n := dst.Len(); n != 1 {
+		t.Errorf("unexpected keys, want 1, have %d", n)
+	}
+
+	if c, _ := dst.Get(ctx, "long", window); c != 4 {
+		t.Errorf("unexpected counter, want 4, have %d", c)
+	}
+
+	clock.Forward(time.Minute)
+
+	if c, _ := dst.Get(ctx, "long", window); c != 0 {
+		t.Errorf("expected restored window to keep its TTL, have counter %d", c)
+	}
+}
@@ -0,0 +1,133 @@
+package pacemaker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+type (
+	DecayingRedisStorageOpts struct {
+		Prefix string
+	}
+
+	// DecayingRedisStorage holds the counter of a DecayingRateLimiter in redis, shared by every rate limiter using
+	// the same prefix. The counter is decayed as of the clock of the rate limiters, which must be in sync.
+	DecayingRedisStorage struct {
+		cli *redis.Client
+
+		key string
+	}
+)
+
+const (
+	// decayingScript decays the counter as of now, ARGV[1] in microseconds, by ARGV[2] per second, then adds
+	// ARGV[3] tokens if they fit in ARGV[4]. The counter expires once fully decayed.
+	decayingScript = `
+		local state = redis.call('HMGET', KEYS[1], 'counter', 'at')
+		local counter = tonumber(state[1]) or 0
+		local at = tonumber(state[2]) or 0
+		local now = tonumber(ARGV[1])
+		local decay = tonumber(ARGV[2])
+		local tokens = tonumber(ARGV[3])
+
+		if now > at then
+			counter = math.max(counter - (now - at) / 1e6 * decay, 0)
+		end
+
+		counter = counter + tokens
+
+		if counter <= tonumber(ARGV[4]) then
+			redis.call('HMSET', KEYS[1], 'counter', tostring(counter), 'at', ARGV[1])
+			if decay > 0 then
+				redis.call('PEXPIRE', KEYS[1], math.ceil(counter / decay * 1000) + 1)
+			end
+		end
+
+		return tostring(counter)
+	`
+	decayingKeySuffix = keySep + "decaying"
+)
+
+var decayingScriptHash = Sha1Hash(decayingScript)
+
+// Load will prepare this storage to be ready for usage, such as
+// load into redis needed lua scripts. Calling to this method is not
+// mandatory, but highly recommended.
+func (s DecayingRedisStorage) Load(ctx context.Context) error {
+	if err := s.cli.ScriptLoad(ctx, decayingScript).Err(); err != nil {
+		return ErrCannotLoadScript
+	}
+	return nil
+}
+
+// Inc decays the counter as of args.Now and increases it by args.Tokens, if there is room to. It returns the
+// counter the tokens would bring, even if exceeding the capacity.
+func (s DecayingRedisStorage) Inc(
+	ctx context.Context,
+	args DecayingIncArgs,
+) (counter float64, err error) {
+	cmd := s.cli.EvalSha(
+		ctx,
+		decayingScriptHash,
+		[]string{s.key},
+		[]any{args.Now.UnixMicro(), args.Decay, args.Tokens, args.Capacity},
+	)
+
+	if err = cmd.Err(); err != nil {
+		if errIsRedisNoScript(err) {
+			if err = s.cli.ScriptLoad(ctx, decayingScript).Err(); err != nil {
+				err = ErrCannotLoadScript
+				return
+			}
+
+			return s.Inc(ctx, args)
+		}
+		return
+	}
+
+	return cmd.Float64()
+}
+
+// Get returns the counter decayed as of now
+func (s DecayingRedisStorage) Get(
+	ctx context.Context,
+	now time.Time,
+	decay float64,
+) (float64, error) {
+	state, err := s.cli.HMGet(ctx, s.key, "counter", "at").Result()
+	if err != nil {
+		return 0, err
+	}
+
+	counter, ok := state[0].(string)
+	at, _ := state[1].(string)
+	if !ok {
+		// key does not exist
+		return 0, nil
+	}
+
+	c, err := strconv.ParseFloat(counter, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	us, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return decayCounter(c, time.UnixMicro(us), now, decay), nil
+}
+
+func NewDecayingRedisStorage(
+	cli *redis.Client,
+	opts DecayingRedisStorageOpts,
+) DecayingRedisStorage {
+	return DecayingRedisStorage{
+		cli: cli,
+		key: opts.Prefix + decayingKeySuffix,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
@@ -47,18 +48,44 @@ const (
 
 		return counter
 	`
+	syncScript = `
+		local counter = tonumber(redis.call('GET', KEYS[1])) or 0
+		local used = tonumber(ARGV[1])
+
+		if used > counter then
+			redis.call('SET', KEYS[1], used, 'PX', ARGV[2])
+			counter = used
+		end
+
+		return counter
+	`
+	penaltyScript = `
+		local current = tonumber(redis.call('GET', KEYS[1])) or 0
+
+		if tonumber(ARGV[1]) > current then
+			redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+		end
+
+		return 0
+	`
+	// penaltyKeyPrefix is kept apart from the prefix of the storage, not to be listed among its windows
+	penaltyKeyPrefix = "penalty" + keySep
 )
 
 var (
-	ScriptHash = Sha1Hash(script)
+	ScriptHash        = Sha1Hash(script)
+	syncScriptHash    = Sha1Hash(syncScript)
+	penaltyScriptHash = Sha1Hash(penaltyScript)
 )
 
 // Load will prepare this storage to be ready for usage, such as
 // load into redis needed lua scripts. Calling to this method is not
 // mandatory, but highly recommended.
 func (s FixedWindowRedisStorage) Load(ctx context.Context) error {
-	if err := s.cli.ScriptLoad(ctx, script).Err(); err != nil {
-		return ErrCannotLoadScript
+	for _, sc := range []string{script, syncScript, penaltyScript} {
+		if err := s.cli.ScriptLoad(ctx, sc).Err(); err != nil {
+			return ErrCannotLoadScript
+		}
 	}
 	return nil
 }
@@ -94,6 +121,71 @@ func (s FixedWindowRedisStorage) Inc(
 	return
 }
 
+// Sync raises the counter for the bucket specified by window argument to the one of args, unless it is higher
+// already
+func (s FixedWindowRedisStorage) Sync(
+	ctx context.Context,
+	args FixedWindowSyncArgs,
+) (counter int64, err error) {
+	key := s.keyGenerator(args.Window)
+
+	cmd := s.cli.EvalSha(
+		ctx,
+		syncScriptHash,
+		[]string{key},
+		[]any{args.Counter, max(args.TTL.Milliseconds(), 1)},
+	)
+
+	if err = cmd.Err(); err != nil {
+		if errIsRedisNoScript(err) {
+			if err = s.cli.ScriptLoad(ctx, syncScript).Err(); err != nil {
+				err = ErrCannotLoadScript
+				return
+			}
+
+			return s.Sync(ctx, args)
+		}
+		return
+	}
+
+	counter, err = cmd.Int64()
+	return
+}
+
+// Penalize blocks every limiter using this storage, or any other one with the same prefix, until the given
+// time, unless blocked for longer already. The penalty is kept for ttl.
+func (s FixedWindowRedisStorage) Penalize(ctx context.Context, until time.Time, ttl time.Duration) error {
+	err := s.cli.EvalSha(
+		ctx,
+		penaltyScriptHash,
+		[]string{penaltyKeyPrefix + s.opts.Prefix},
+		[]any{until.UnixNano(), max(ttl.Milliseconds(), 1)},
+	).Err()
+
+	if err != nil && errIsRedisNoScript(err) {
+		if err = s.cli.ScriptLoad(ctx, penaltyScript).Err(); err != nil {
+			return ErrCannotLoadScript
+		}
+
+		return s.Penalize(ctx, until, ttl)
+	}
+
+	return err
+}
+
+// Penalty returns the time the current penalty ends at, zero if there is none
+func (s FixedWindowRedisStorage) Penalty(ctx context.Context) (time.Time, error) {
+	raw, err := s.cli.Get(ctx, penaltyKeyPrefix+s.opts.Prefix).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return TimeFromNsStr(raw)
+}
+
 func (s FixedWindowRedisStorage) Keys(ctx context.Context) (res []string, err error) {
 	cmd := s.cli.Keys(ctx, s.opts.Prefix+"*")
 	if err = cmd.Err(); err != nil {
@@ -121,6 +213,70 @@ func (s FixedWindowRedisStorage) LastWindow(ctx context.Context) (ts time.Time,
 	return
 }
 
+// Snapshot returns the windows of this storage along their remaining TTL, in the format of memory storage
+// snapshots. Mostly useful as the source of a migration, see Migrate. The time of the snapshot is taken from
+// the redis server, as TTLs are.
+func (s FixedWindowRedisStorage) Snapshot(ctx context.Context) ([]byte, error) {
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.cli.Time(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		gets = make([]*redis.StringCmd, 0, len(keys))
+		ttls = make([]*redis.DurationCmd, 0, len(keys))
+	)
+
+	pipe := s.cli.Pipeline()
+
+	for _, key := range keys {
+		gets = append(gets, pipe.Get(ctx, key))
+		ttls = append(ttls, pipe.PTTL(ctx, key))
+	}
+
+	if _, err = pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	var windows []snapshotWindow
+
+	for i, key := range keys {
+		if !strings.HasPrefix(key, s.opts.Prefix+keySep) {
+			// Belongs to another storage whose prefix starts with ours
+			continue
+		}
+
+		window, err := TimeFromNsStr(strings.TrimPrefix(key, s.opts.Prefix+keySep))
+		if err != nil {
+			continue
+		}
+
+		counter, err := gets[i].Int64()
+		if err != nil {
+			// Expired since listed
+			continue
+		}
+
+		ttl := ttls[i].Val()
+		if ttl <= 0 {
+			continue
+		}
+
+		windows = append(windows, snapshotWindow{
+			Window:  window,
+			Counter: counter,
+			TTL:     ttl,
+		})
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
 func (s FixedWindowRedisStorage) Get(
 	ctx context.Context,
 	window time.Time,
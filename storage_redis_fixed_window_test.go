@@ -0,0 +1,96 @@
+package pacemaker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+func TestFixedWindowRedisStorage_Conformance(t *testing.T) {
+	var srv *pacemakertest.Redis
+
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			srv = pacemakertest.NewRedis(t)
+			return pacemaker.NewFixedWindowRedisStorage(
+				srv.Client,
+				pacemaker.FixedWindowRedisStorageOpts{Prefix: "pacemaker|conformance"},
+			)
+		},
+		Advance: func(d time.Duration) {
+			srv.FastForward(d)
+		},
+		Expires:  true,
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+func TestFixedWindowRedisStorage_ReloadsScriptOnNoScript(t *testing.T) {
+	ctx := context.Background()
+	srv := pacemakertest.NewRedis(t)
+	storage := pacemaker.NewFixedWindowRedisStorage(
+		srv.Client,
+		pacemaker.FixedWindowRedisStorageOpts{Prefix: "pacemaker|noscript"},
+	)
+
+	if err := storage.Load(ctx); err != nil {
+		t.Fatalf("unexpected error on load, want none, have %v", err)
+	}
+
+	if err := srv.FlushScripts(ctx); err != nil {
+		t.Fatalf("unexpected error on script flush, want none, have %v", err)
+	}
+
+	window := time.Date(2022, 02, 05, 10, 23, 30, 0, time.UTC)
+
+	c, err := storage.Inc(ctx, pacemaker.FixedWindowIncArgs{
+		Window:   window,
+		TTL:      time.Second * 7,
+		Tokens:   2,
+		Capacity: 10,
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error on inc, want none, have %v", err)
+	}
+
+	if c != 2 {
+		t.Errorf("unexpected counter, want 2, have %d", c)
+	}
+}
+
+func TestFixedWindowRedisStorage_SetsTTL(t *testing.T) {
+	ctx := context.Background()
+	srv := pacemakertest.NewRedis(t)
+	storage := pacemaker.NewFixedWindowRedisStorage(
+		srv.Client,
+		pacemaker.FixedWindowRedisStorageOpts{Prefix: "pacemaker|ttl"},
+	)
+
+	window := time.Date(2022, 02, 05, 10, 23, 30, 0, time.UTC)
+
+	for _, ttl := range []time.Duration{time.Second * 7, time.Second * 3} {
+		_, err := storage.Inc(ctx, pacemaker.FixedWindowIncArgs{
+			Window:   window,
+			TTL:      ttl,
+			Tokens:   1,
+			Capacity: 10,
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error on inc, want none, have %v", err)
+		}
+
+		keys := srv.Keys()
+		if len(keys) != 1 {
+			t.Fatalf("unexpected keys, want 1, have %v", keys)
+		}
+
+		if actual := srv.TTL(keys[0]); actual != ttl {
+			t.Errorf("unexpected ttl, want %v, have %v", ttl, actual)
+		}
+	}
+}
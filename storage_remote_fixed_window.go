@@ -0,0 +1,288 @@
+package pacemaker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	RemoteClientOpts struct {
+		// URL of the TokenServer, e.g. "http://pacemaker:8080"
+		URL string
+		// HTTPClient sends the requests. Defaults to http.DefaultClient.
+		HTTPClient *http.Client
+		// Timeout bounds how long a request to the TokenServer may take. Defaults to 5 seconds.
+		Timeout time.Duration
+		// MaxBatch bounds how many operations are sent per request. Defaults to 128.
+		MaxBatch int
+		// Concurrency is how many requests are in flight at once. Defaults to 4.
+		Concurrency int
+	}
+
+	// RemoteClient sends storage operations to a TokenServer. Operations issued while all its requests are in
+	// flight are batched into the next request, so that busy clients make fewer round trips without adding
+	// latency to idle ones.
+	RemoteClient struct {
+		opts RemoteClientOpts
+
+		url   string
+		queue chan *remoteCall
+		done  chan struct{}
+		once  sync.Once
+		wg    sync.WaitGroup
+		// ctx is canceled on Close, aborting the requests in flight
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+
+	// FixedWindowRemoteStorage keeps the rate limit state of a key in a TokenServer. Obtain it from
+	// RemoteClient.Storage.
+	FixedWindowRemoteStorage struct {
+		cli *RemoteClient
+		key string
+	}
+
+	remoteCall struct {
+		ctx context.Context
+		op  storageOp
+		res chan remoteReply
+	}
+
+	remoteReply struct {
+		result storageOpResult
+		err    error
+	}
+)
+
+const (
+	defaultRemoteMaxBatch    = 128
+	defaultRemoteConcurrency = 4
+	defaultRemoteTimeout     = 5 * time.Second
+)
+
+// Storage returns the storage of key, suitable for any fixed window rate limiter
+func (c *RemoteClient) Storage(key string) FixedWindowRemoteStorage {
+	return FixedWindowRemoteStorage{cli: c, key: key}
+}
+
+// Close stops sending operations, aborting the requests in flight. Pending ones fail with ErrRemoteClientClosed.
+func (c *RemoteClient) Close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.cancel()
+	})
+	c.wg.Wait()
+}
+
+func (c *RemoteClient) call(ctx context.Context, op storageOp) (storageOpResult, error) {
+	if err := ctx.Err(); err != nil {
+		return storageOpResult{}, err
+	}
+
+	call := &remoteCall{ctx: ctx, op: op, res: make(chan remoteReply, 1)}
+
+	select {
+	case c.queue <- call:
+	case <-ctx.Done():
+		return storageOpResult{}, ctx.Err()
+	case <-c.done:
+		return storageOpResult{}, ErrRemoteClientClosed
+	}
+
+	select {
+	case reply := <-call.res:
+		return reply.result, reply.err
+	case <-ctx.Done():
+		return storageOpResult{}, ctx.Err()
+	}
+}
+
+func (c *RemoteClient) work() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.done:
+			c.drain()
+			return
+		case call := <-c.queue:
+			batch := []*remoteCall{call}
+
+		collect:
+			for len(batch) < c.opts.MaxBatch {
+				select {
+				case call := <-c.queue:
+					batch = append(batch, call)
+				default:
+					break collect
+				}
+			}
+
+			c.send(batch)
+		}
+	}
+}
+
+// drain fails the operations queued after closing
+func (c *RemoteClient) drain() {
+	for {
+		select {
+		case call := <-c.queue:
+			call.res <- remoteReply{err: ErrRemoteClientClosed}
+		default:
+			return
+		}
+	}
+}
+
+func (c *RemoteClient) send(batch []*remoteCall) {
+	// Operations whose caller gave up are not sent
+	live := batch[:0]
+	for _, call := range batch {
+		if err := call.ctx.Err(); err != nil {
+			call.res <- remoteReply{err: err}
+			continue
+		}
+		live = append(live, call)
+	}
+
+	if len(live) == 0 {
+		return
+	}
+
+	req := storageBatchRequest{Ops: make([]storageOp, len(live))}
+	for i, call := range live {
+		req.Ops[i] = call.op
+	}
+
+	res, err := c.post(req)
+
+	if err == nil && len(res.Results) != len(live) {
+		err = fmt.Errorf("token server replied %d results for %d operations", len(res.Results), len(live))
+	}
+
+	for i, call := range live {
+		if err != nil {
+			call.res <- remoteReply{err: err}
+			continue
+		}
+		call.res <- remoteReply{result: res.Results[i], err: decodeWireError(res.Results[i].Error)}
+	}
+}
+
+func (c *RemoteClient) post(req storageBatchRequest) (res storageBatchResponse, err error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.opts.Timeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.opts.HTTPClient.Do(r)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("token server replied %s", resp.Status)
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	return
+}
+
+// Inc will increase, if there is room to, the rate limiting counter for the bucket
+// specified by window argument.
+func (s FixedWindowRemoteStorage) Inc(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (int64, error) {
+	res, err := s.cli.call(ctx, storageOp{
+		Op:       storageOpInc,
+		Key:      s.key,
+		Window:   args.Window.UnixNano(),
+		TTL:      int64(args.TTL),
+		Tokens:   args.Tokens,
+		Capacity: args.Capacity,
+	})
+
+	return res.Counter, err
+}
+
+func (s FixedWindowRemoteStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (int64, error) {
+	res, err := s.cli.call(ctx, storageOp{
+		Op:     storageOpGet,
+		Key:    s.key,
+		Window: window.UnixNano(),
+	})
+
+	return res.Counter, err
+}
+
+func (s FixedWindowRemoteStorage) LastWindow(ctx context.Context) (time.Time, error) {
+	res, err := s.cli.call(ctx, storageOp{
+		Op:  storageOpLastWindow,
+		Key: s.key,
+	})
+
+	if err != nil || res.Window == 0 {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, res.Window), nil
+}
+
+// NewRemoteClient returns a new instance of RemoteClient. Call Close to release it.
+func NewRemoteClient(opts RemoteClientOpts) *RemoteClient {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = defaultRemoteMaxBatch
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultRemoteConcurrency
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRemoteTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &RemoteClient{
+		opts:   opts,
+		url:    strings.TrimSuffix(opts.URL, "/") + tokenServerStoragePath,
+		queue:  make(chan *remoteCall),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	c.wg.Add(opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		go c.work()
+	}
+
+	return c
+}
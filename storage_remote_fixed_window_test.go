@@ -0,0 +1,190 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+// newTokenServer serves a TokenServer backed by memory, counting the HTTP requests it receives
+func newTokenServer(t *testing.T, clock *pacemaker.TestClock, args pacemaker.TokenServerArgs) (*httptest.Server, *int64) {
+	t.Helper()
+
+	if args.Storage == nil {
+		args.Storage = pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock})
+	}
+
+	var requests int64
+	handler := pacemaker.NewTokenServer(args)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func newRemoteClient(t *testing.T, opts pacemaker.RemoteClientOpts) *pacemaker.RemoteClient {
+	t.Helper()
+
+	cli := pacemaker.NewRemoteClient(opts)
+	t.Cleanup(cli.Close)
+
+	return cli
+}
+
+func TestFixedWindowRemoteStorage_Conformance(t *testing.T) {
+	pacemakertest.RunStorageSuite(t, pacemakertest.Harness{
+		New: func(t *testing.T, clock *pacemaker.TestClock) pacemakertest.Storage {
+			srv, _ := newTokenServer(t, clock, pacemaker.TokenServerArgs{})
+			return newRemoteClient(t, pacemaker.RemoteClientOpts{URL: srv.URL}).Storage("remote")
+		},
+		Expires:  true,
+		Overflow: pacemakertest.OverflowReject,
+	})
+}
+
+func TestFixedWindowRemoteStorage_LastWindow(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	srv, _ := newTokenServer(t, clock, pacemaker.TokenServerArgs{})
+	s := newRemoteClient(t, pacemaker.RemoteClientOpts{URL: srv.URL}).Storage("remote")
+
+	if _, err := s.LastWindow(ctx); !errors.Is(err, pacemaker.ErrNoLastKey) {
+		t.Fatalf("unexpected error, want %v, have %v", pacemaker.ErrNoLastKey, err)
+	}
+
+	window := clock.Now()
+	_, _ = s.Inc(ctx, pacemaker.FixedWindowIncArgs{Window: window, TTL: time.Minute, Tokens: 1, Capacity: 10})
+
+	last, err := s.LastWindow(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if !last.Equal(window) {
+		t.Errorf("unexpected last window, want %v, have %v", window, last)
+	}
+}
+
+func TestRemoteClient_Batches(t *testing.T) {
+	ctx := context.Background()
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+	srv, requests := newTokenServer(t, clock, pacemaker.TokenServerArgs{})
+	cli := newRemoteClient(t, pacemaker.RemoteClientOpts{URL: srv.URL, Concurrency: 1})
+
+	const incs = 200
+
+	var (
+		wg       sync.WaitGroup
+		admitted int64
+	)
+
+	wg.Add(incs)
+	for i := 0; i < incs; i++ {
+		go func() {
+			defer wg.Done()
+
+			c, err := cli.Storage("batched").Inc(ctx, pacemaker.FixedWindowIncArgs{
+				Window:   clock.Now(),
+				TTL:      time.Minute,
+				Tokens:   1,
+				Capacity: 150,
+			})
+			if err != nil {
+				t.Errorf("unexpected error, want none, have %v", err)
+			}
+			if c <= 150 {
+				atomic.AddInt64(&admitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if admitted != 150 {
+		t.Errorf("unexpected admitted requests, want 150, have %d", admitted)
+	}
+
+	if n := atomic.LoadInt64(requests); n >= incs {
+		t.Errorf("expected operations to be batched, have %d requests for %d operations", n, incs)
+	}
+}
+
+func TestRemoteClient_Errors(t *testing.T) {
+	ctx := context.Background()
+	s := newRemoteClient(t, pacemaker.RemoteClientOpts{URL: "http://127.0.0.1:1"}).Storage("down")
+
+	if _, err := s.Get(ctx, time.Now()); err == nil {
+		t.Error("expected error from an unreachable server, have none")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := s.Get(cancelled, time.Now()); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error, want %v, have %v", context.Canceled, err)
+	}
+
+	cli := pacemaker.NewRemoteClient(pacemaker.RemoteClientOpts{URL: "http://127.0.0.1:1"})
+	cli.Close()
+
+	if _, err := cli.Storage("closed").Get(ctx, time.Now()); !errors.Is(err, pacemaker.ErrRemoteClientClosed) {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrRemoteClientClosed, err)
+	}
+}
+
+func TestRemoteClient_HungServer(t *testing.T) {
+	ctx := context.Background()
+
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-hung:
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(hung) })
+
+	cli := newRemoteClient(t, pacemaker.RemoteClientOpts{URL: srv.URL, Timeout: 50 * time.Millisecond})
+
+	if _, err := cli.Storage("hung").Get(ctx, time.Now()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error, want %v, have %v", context.DeadlineExceeded, err)
+	}
+
+	cli = pacemaker.NewRemoteClient(pacemaker.RemoteClientOpts{URL: srv.URL, Timeout: time.Hour})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cli.Storage("hung").Get(ctx, time.Now())
+		errs <- err
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		// Give the request time to be in flight
+		time.Sleep(50 * time.Millisecond)
+		cli.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hung on a request in flight")
+	}
+
+	if err := <-errs; err == nil {
+		t.Error("expected error from an aborted request, have none")
+	}
+}
@@ -0,0 +1,309 @@
+package pacemaker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type (
+	// SQLDialect adapts the queries of FixedWindowSQLStorage to a database engine
+	SQLDialect struct {
+		name        string
+		placeholder func(n int) string
+	}
+
+	FixedWindowSQLStorageOpts struct {
+		// Table holds the rate limit windows. Defaults to "pacemaker_windows".
+		Table string
+		// Key identifies the rate limit within the table. Several limiters can share the same table as long
+		// as their keys differ.
+		Key string
+		// Dialect of the database. Defaults to SQLDialectPostgres.
+		Dialect SQLDialect
+		// Clock is used to expire stale windows. Defaults to RealClock.
+		Clock clock
+	}
+
+	// FixedWindowSQLStorage keeps the rate limit state in a relational database accessed through database/sql.
+	// Every window is a row, increased by an atomic upsert that checks the capacity. Expired rows are ignored
+	// by reads and must be cleaned up by calling Expire periodically, see RunJanitor.
+	//
+	// When using SQLite, concurrent writers from different connections fail with SQLITE_BUSY: limit the pool
+	// to a single connection with db.SetMaxOpenConns(1).
+	FixedWindowSQLStorage struct {
+		db *sql.DB
+
+		opts FixedWindowSQLStorageOpts
+
+		queries sqlQueries
+	}
+
+	sqlQueries struct {
+		createTable string
+		createIndex string
+		inc         string
+		get         string
+		lastWindow  string
+		snapshot    string
+		expire      string
+	}
+)
+
+var (
+	SQLDialectSQLite = SQLDialect{
+		name: "sqlite",
+		placeholder: func(int) string {
+			return "?"
+		},
+	}
+
+	SQLDialectPostgres = SQLDialect{
+		name: "postgres",
+		placeholder: func(n int) string {
+			return fmt.Sprintf("$%d", n)
+		},
+	}
+
+	sqlTableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+const (
+	defaultSQLTable = "pacemaker_windows"
+)
+
+func (d SQLDialect) String() string {
+	return d.name
+}
+
+// bind replaces every '?' of query with the placeholders of the dialect
+func (d SQLDialect) bind(query string) string {
+	var (
+		b strings.Builder
+		n int
+	)
+
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func newSQLQueries(d SQLDialect, table string) sqlQueries {
+	return sqlQueries{
+		createTable: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			limiter_key TEXT NOT NULL,
+			window_ns BIGINT NOT NULL,
+			counter BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			PRIMARY KEY (limiter_key, window_ns)
+		)`, table),
+		createIndex: fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %s_expires_at_idx ON %s (expires_at)`,
+			table, table,
+		),
+		// Rows whose TTL has elapsed are restarted from scratch. The WHERE clauses skip both the insertion and
+		// the update when tokens do not fit, in which case no row is returned.
+		inc: d.bind(fmt.Sprintf(`INSERT INTO %s AS w (limiter_key, window_ns, counter, expires_at)
+			SELECT CAST(? AS TEXT), CAST(? AS BIGINT), CAST(? AS BIGINT), CAST(? AS BIGINT)
+			WHERE CAST(? AS BIGINT) <= CAST(? AS BIGINT)
+			ON CONFLICT (limiter_key, window_ns) DO UPDATE SET
+				counter = CASE WHEN w.expires_at <= ? THEN excluded.counter ELSE w.counter + excluded.counter END,
+				expires_at = excluded.expires_at
+			WHERE CASE WHEN w.expires_at <= ? THEN 0 ELSE w.counter END + excluded.counter <= ?
+			RETURNING counter`, table)),
+		get: d.bind(fmt.Sprintf(
+			`SELECT counter FROM %s WHERE limiter_key = ? AND window_ns = ? AND expires_at > ?`,
+			table,
+		)),
+		lastWindow: d.bind(fmt.Sprintf(
+			`SELECT window_ns FROM %s WHERE limiter_key = ? AND expires_at > ? ORDER BY window_ns DESC LIMIT 1`,
+			table,
+		)),
+		snapshot: d.bind(fmt.Sprintf(
+			`SELECT window_ns, counter, expires_at FROM %s WHERE limiter_key = ? AND expires_at > ?`,
+			table,
+		)),
+		expire: d.bind(fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= ?`, table)),
+	}
+}
+
+// CreateTable creates, if missing, the table and indexes this storage needs. Calling to this method is not
+// mandatory if the schema is managed by other means.
+func (s FixedWindowSQLStorage) CreateTable(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, s.queries.createTable); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, s.queries.createIndex)
+	return err
+}
+
+// Inc will increase, if there is room to, the rate limiting counter for the bucket
+// specified by window argument.
+func (s FixedWindowSQLStorage) Inc(
+	ctx context.Context,
+	args FixedWindowIncArgs,
+) (counter int64, err error) {
+	now := s.opts.Clock.Now().UnixNano()
+	window := args.Window.UnixNano()
+
+	err = s.db.QueryRowContext(
+		ctx,
+		s.queries.inc,
+		s.opts.Key, window, args.Tokens, now+args.TTL.Nanoseconds(),
+		args.Tokens, args.Capacity,
+		now,
+		now, args.Capacity,
+	).Scan(&counter)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		// Tokens did not fit, report how far from the capacity the request was
+		counter, err = s.Get(ctx, args.Window)
+		counter += args.Tokens
+	}
+
+	return
+}
+
+func (s FixedWindowSQLStorage) Get(
+	ctx context.Context,
+	window time.Time,
+) (counter int64, err error) {
+	err = s.db.QueryRowContext(
+		ctx,
+		s.queries.get,
+		s.opts.Key, window.UnixNano(), s.opts.Clock.Now().UnixNano(),
+	).Scan(&counter)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		counter, err = 0, nil
+	}
+
+	return
+}
+
+func (s FixedWindowSQLStorage) LastWindow(ctx context.Context) (ts time.Time, err error) {
+	var ns int64
+
+	err = s.db.QueryRowContext(
+		ctx,
+		s.queries.lastWindow,
+		s.opts.Key, s.opts.Clock.Now().UnixNano(),
+	).Scan(&ns)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNoLastKey
+		}
+		return
+	}
+
+	ts = time.Unix(0, ns)
+	return
+}
+
+// Snapshot returns the windows of this storage whose TTL has not elapsed, in the format of memory storage
+// snapshots. Mostly useful as the source of a migration, see Migrate.
+func (s FixedWindowSQLStorage) Snapshot(ctx context.Context) ([]byte, error) {
+	now := s.opts.Clock.Now()
+
+	rows, err := s.db.QueryContext(ctx, s.queries.snapshot, s.opts.Key, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []snapshotWindow
+
+	for rows.Next() {
+		var window, counter, expiresAt int64
+
+		if err := rows.Scan(&window, &counter, &expiresAt); err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, snapshotWindow{
+			Window:  time.Unix(0, window),
+			Counter: counter,
+			TTL:     time.Unix(0, expiresAt).Sub(now),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return encodeSnapshot(now, windows)
+}
+
+// Expire deletes the rows of every key in the table whose TTL has elapsed, returning how many were deleted
+func (s FixedWindowSQLStorage) Expire(ctx context.Context) (int64, error) {
+	r, err := s.db.ExecContext(ctx, s.queries.expire, s.opts.Clock.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	return r.RowsAffected()
+}
+
+// RunJanitor calls Expire every interval until the context is done. Errors are handed to onErr, which may be
+// nil. Typically run in its own goroutine by one of the processes sharing the table.
+func (s FixedWindowSQLStorage) RunJanitor(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Expire(ctx); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// NewFixedWindowSQLStorage returns a new instance of FixedWindowSQLStorage on top of an already opened database.
+// Closing the database is up to the caller.
+func NewFixedWindowSQLStorage(
+	db *sql.DB,
+	opts FixedWindowSQLStorageOpts,
+) (FixedWindowSQLStorage, error) {
+	if opts.Key == "" {
+		return FixedWindowSQLStorage{}, ErrEmptyKey
+	}
+
+	if opts.Table == "" {
+		opts.Table = defaultSQLTable
+	}
+
+	if !sqlTableNameRe.MatchString(opts.Table) {
+		return FixedWindowSQLStorage{}, ErrInvalidTableName
+	}
+
+	if opts.Dialect.placeholder == nil {
+		opts.Dialect = SQLDialectPostgres
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	return FixedWindowSQLStorage{
+		db:      db,
+		opts:    opts,
+		queries: newSQLQueries(opts.Dialect, opts.Table),
+	}, nil
+}
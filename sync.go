@@ -0,0 +1,108 @@
+package pacemaker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	FixedWindowSyncArgs struct {
+		Window time.Time
+		TTL    time.Duration
+		// Counter is the usage of the window reported by the server
+		Counter int64
+	}
+
+	// counterSyncer is implemented by storages able to raise the counter of a window at once, atomically
+	counterSyncer interface {
+		Sync(ctx context.Context, args FixedWindowSyncArgs) (int64, error)
+	}
+
+	// keyedCounterSyncer is counterSyncer for storages holding many keys, such as KeyedMemoryStorage
+	keyedCounterSyncer interface {
+		Sync(ctx context.Context, key string, args FixedWindowSyncArgs) (int64, error)
+	}
+
+	// usageSyncer is a rate limiter whose usage can be synced with the one reported by a server, such as
+	// FixedWindowRateLimiter
+	usageSyncer interface {
+		Sync(ctx context.Context, used int64, at time.Time) error
+	}
+
+	// windowMatcher is a usageSyncer telling whether two times fall in its current window
+	windowMatcher interface {
+		sameWindow(a, b time.Time) bool
+	}
+
+	// UsageHeader maps a response header reporting the usage of a rate limit, e.g. X-MBX-USED-WEIGHT-1M, to the
+	// limiter modelling it
+	UsageHeader struct {
+		Header  string
+		Limiter usageSyncer
+	}
+)
+
+// syncCounter raises the counter of a window of db to args.Counter, unless it is higher already. Storages not
+// implementing Sync are read and increased by the difference, see addCounter.
+func syncCounter(ctx context.Context, db fixedTruncatedWindowStorage, args FixedWindowSyncArgs) (int64, error) {
+	if s, ok := db.(counterSyncer); ok {
+		return s.Sync(ctx, args)
+	}
+
+	return addCounter(ctx, db, args)
+}
+
+// addCounter reads the counter of a window of db and increases it by the difference to args.Counter, which may
+// overshoot if other processes increase the counter in between
+func addCounter(ctx context.Context, db fixedTruncatedWindowStorage, args FixedWindowSyncArgs) (int64, error) {
+	c, err := db.Get(ctx, args.Window)
+	if err != nil || c >= args.Counter {
+		return c, err
+	}
+
+	return db.Inc(ctx, FixedWindowIncArgs{
+		Window:   args.Window,
+		TTL:      args.TTL,
+		Tokens:   args.Counter - c,
+		Capacity: math.MaxInt64,
+	})
+}
+
+// SyncUsage syncs the limiters of headers with the usage reported by resp, as of its Date header, or as of now if
+// it is missing. As the Date header is truncated to the second, windows starting within that second would drop the
+// usage, so limiters telling their windows, such as FixedWindowRateLimiter, are only synced as of the Date header
+// when it falls in the same window as now. Headers missing from the response are skipped. Typically called by
+// Transport, see TransportOpts.Usage.
+func SyncUsage(ctx context.Context, resp *http.Response, headers []UsageHeader, now time.Time) error {
+	date, dateErr := http.ParseTime(resp.Header.Get("Date"))
+
+	for _, h := range headers {
+		value := strings.TrimSpace(resp.Header.Get(h.Header))
+		if value == "" {
+			continue
+		}
+
+		used, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid usage header %s: %w", h.Header, err)
+		}
+
+		at := now
+		if dateErr == nil {
+			if w, ok := h.Limiter.(windowMatcher); !ok || w.sameWindow(date, now) {
+				at = date
+			}
+		}
+
+		if err := h.Limiter.Sync(ctx, used, at); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+	"github.com/sonirico/pacemaker/pacemakertest"
+)
+
+type syncedLimiter interface {
+	Try(ctx context.Context, tokens int64) (pacemaker.Result, error)
+	Sync(ctx context.Context, used int64, at time.Time) error
+}
+
+func TestLimiters_Sync(t *testing.T) {
+	start := time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC)
+
+	newTruncated := func(clock *pacemaker.TestClock, db pacemakertest.Storage) syncedLimiter {
+		l := pacemaker.NewTokenFixedWindowRateLimiter(
+			pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+				Capacity: 10,
+				Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+				Clock:    clock,
+				DB:       db,
+			}),
+		)
+		return &l
+	}
+
+	keyed := func(clock *pacemaker.TestClock) pacemakertest.Storage {
+		return pacemaker.NewKeyedMemoryStorage(pacemaker.KeyedMemoryStorageOpts{Clock: clock}).For("sync")
+	}
+
+	tests := []struct {
+		name    string
+		limiter func(*pacemaker.TestClock, pacemakertest.Storage) syncedLimiter
+		storage func(*pacemaker.TestClock) pacemakertest.Storage
+	}{
+		{
+			name:    "truncated window, memory storage",
+			limiter: newTruncated,
+			storage: func(clock *pacemaker.TestClock) pacemakertest.Storage {
+				return pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock)
+			},
+		},
+		{
+			name: "fixed window, memory storage",
+			limiter: func(clock *pacemaker.TestClock, _ pacemakertest.Storage) syncedLimiter {
+				l := pacemaker.NewTokenFixedWindowRateLimiter(
+					pacemaker.NewFixedWindowRateLimiter(pacemaker.FixedWindowArgs{
+						Capacity: 10,
+						Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+						Clock:    clock,
+						DB:       pacemaker.NewFixedWindowMemoryStorageWithClock(clock),
+					}),
+				)
+				return &l
+			},
+			storage: func(*pacemaker.TestClock) pacemakertest.Storage { return nil },
+		},
+		{
+			name:    "truncated window, keyed memory storage",
+			limiter: newTruncated,
+			storage: keyed,
+		},
+		{
+			name:    "truncated window, redis storage",
+			limiter: newTruncated,
+			storage: func(*pacemaker.TestClock) pacemakertest.Storage {
+				return pacemaker.NewFixedWindowRedisStorage(
+					pacemakertest.NewRedis(t).Client,
+					pacemaker.FixedWindowRedisStorageOpts{Prefix: "sync"},
+				)
+			},
+		},
+		{
+			name:    "truncated window, storage without sync",
+			limiter: newTruncated,
+			storage: func(clock *pacemaker.TestClock) pacemakertest.Storage {
+				return pacemakertest.NewFaultyStorage(keyed(clock), pacemakertest.FaultConfig{})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := pacemaker.NewMockClock(start)
+			l := test.limiter(clock, test.storage(clock))
+
+			steps := []struct {
+				name              string
+				used              int64
+				at                time.Time
+				tokens            int64
+				expectedFreeSlots int64
+				expectedErr       error
+			}{
+				{name: "no sync", tokens: 2, expectedFreeSlots: 8},
+				{name: "server reports more", used: 6, at: start, tokens: 1, expectedFreeSlots: 3},
+				{name: "server reports less", used: 1, at: start, tokens: 1, expectedFreeSlots: 2},
+				{name: "other window", used: 9, at: start.Add(-time.Minute), tokens: 1, expectedFreeSlots: 1},
+				{name: "server exhausted", used: 10, at: start, tokens: 1, expectedErr: pacemaker.ErrRateLimitExceeded},
+			}
+
+			for _, step := range steps {
+				if !step.at.IsZero() {
+					if err := l.Sync(ctx, step.used, step.at); err != nil {
+						t.Fatalf("%s: unexpected error, want none, have %v", step.name, err)
+					}
+				}
+
+				r, err := l.Try(ctx, step.tokens)
+
+				if !errors.Is(err, step.expectedErr) {
+					t.Fatalf("%s: unexpected error, want %v, have %v", step.name, step.expectedErr, err)
+				}
+
+				if err == nil && r.FreeSlots != step.expectedFreeSlots {
+					t.Errorf("%s: unexpected free slots, want %d, have %d", step.name, step.expectedFreeSlots, r.FreeSlots)
+				}
+			}
+		})
+	}
+}
+
+func TestTransport_SyncUsage(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		skew, _ := time.ParseDuration(r.URL.Query().Get("skew"))
+		w.Header().Set("Date", clock.Now().Add(skew).Format(http.TimeFormat))
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", r.URL.Query().Get("used"))
+	}))
+	t.Cleanup(srv.Close)
+
+	limiter := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+			Capacity: 1200,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock),
+		}),
+	)
+
+	var usageErr error
+
+	cli := &http.Client{Transport: pacemaker.NewTransport(pacemaker.TransportOpts{
+		Limiter:      &limiter,
+		Usage:        []pacemaker.UsageHeader{{Header: "X-MBX-USED-WEIGHT-1M", Limiter: &limiter}},
+		OnUsageError: func(err error) { usageErr = err },
+		Clock:        clock,
+	})}
+
+	tests := []struct {
+		used              string
+		skew              time.Duration
+		expectedFreeSlots int64
+		expectedUsageErr  bool
+	}{
+		{used: "", expectedFreeSlots: 1199},
+		{used: "700", expectedFreeSlots: 500},
+		{used: "nope", expectedFreeSlots: 499, expectedUsageErr: true},
+		// The Date header falls in the previous window, as the clock of the server lags behind, so the local clock
+		// tells the window instead
+		{used: "1000", skew: -time.Second, expectedFreeSlots: 200},
+		{used: "1100", skew: 30 * time.Second, expectedFreeSlots: 100},
+	}
+
+	for _, test := range tests {
+		usageErr = nil
+
+		resp, err := cli.Get(srv.URL + "?used=" + test.used + "&skew=" + test.skew.String())
+		if err != nil {
+			t.Fatalf("unexpected error, want none, have %v", err)
+		}
+		resp.Body.Close()
+
+		if (usageErr != nil) != test.expectedUsageErr {
+			t.Errorf("used %q: unexpected usage error, want error=%v, have %v", test.used, test.expectedUsageErr, usageErr)
+		}
+
+		r, _ := limiter.Dump(context.Background())
+		if r.FreeSlots != test.expectedFreeSlots {
+			t.Errorf("used %q: unexpected free slots, want %d, have %d", test.used, test.expectedFreeSlots, r.FreeSlots)
+		}
+	}
+}
+
+func TestSyncUsage_DateTruncated(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC)
+	clock := pacemaker.NewMockClock(start.Add(500 * time.Millisecond))
+
+	limiter := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedWindowRateLimiter(pacemaker.FixedWindowArgs{
+			Capacity: 10,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       pacemaker.NewFixedWindowMemoryStorageWithClock(clock),
+		}),
+	)
+
+	// The window starts half a second after the second the Date header is truncated to
+	if _, err := limiter.Try(ctx, 1); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	clock.Forward(100 * time.Millisecond)
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Date", clock.Now().Format(http.TimeFormat))
+	resp.Header.Set("X-MBX-USED-WEIGHT-1M", "6")
+
+	headers := []pacemaker.UsageHeader{{Header: "X-MBX-USED-WEIGHT-1M", Limiter: &limiter}}
+	if err := pacemaker.SyncUsage(ctx, resp, headers, clock.Now()); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if r, _ := limiter.Dump(ctx); r.FreeSlots != 4 {
+		t.Errorf("unexpected free slots, want 4, have %d", r.FreeSlots)
+	}
+}
@@ -0,0 +1,155 @@
+package pacemaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type (
+	// tokenLimiter consumes tokens from a rate limit, such as TokenFixedWindowRateLimiter
+	tokenLimiter interface {
+		Try(ctx context.Context, tokens int64) (Result, error)
+	}
+
+	TransportOpts struct {
+		// Base sends the requests once paced. Defaults to http.DefaultTransport.
+		Base http.RoundTripper
+		// Limiter is consumed the weight of every request
+		Limiter tokenLimiter
+		// Weight returns how many tokens the request consumes, e.g. the weight of its endpoint. Defaults to 1.
+		Weight func(r *http.Request) int64
+		// Usage lists the response headers reporting the usage of the rate limits of the server, to sync the
+		// limiters modelling them with, see SyncUsage
+		Usage []UsageHeader
+		// Penalize blocks the limiters, Limiter and the ones of Usage, when the server replies 429 Too Many
+		// Requests or 418, as Binance does once it bans a client, until the time in Retry-After. See the Penalize
+		// method of the limiters.
+		Penalize bool
+		// OnUsageError, if set, is handed the errors syncing the usage or penalizing the limiters. They never fail
+		// the request.
+		OnUsageError func(error)
+		// Clock is used to wait for the rate limit to free up. Defaults to RealClock.
+		Clock timerClock
+	}
+
+	// Transport is an http.RoundTripper pacing the outgoing requests to stay under the rate limit of a server. Every
+	// request waits until its weight fits in the limiter before being sent, or until its context is done.
+	Transport struct {
+		opts TransportOpts
+	}
+)
+
+// minRetryWait bounds how often a rejected request retries, should the limiter not tell how long to wait
+const minRetryWait = time.Millisecond
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// Weight may read the body, as Weights.Request does with forms. Transports must not modify the requests they
+	// are handed, so requests whose body cannot be got again are cloned for it to be replaced in the clone.
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		r = r.Clone(r.Context())
+	}
+
+	if _, err := Wait(r.Context(), t.opts.Limiter, t.opts.Weight(r), t.opts.Clock); err != nil {
+		closeRequestBody(r)
+		return nil, err
+	}
+
+	resp, err := t.opts.Base.RoundTrip(r)
+	if err != nil {
+		return resp, err
+	}
+
+	now := t.opts.Clock.Now()
+
+	if len(t.opts.Usage) > 0 {
+		t.report(SyncUsage(r.Context(), resp, t.opts.Usage, now))
+	}
+
+	if t.opts.Penalize && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot) {
+		if until, ok := retryAfter(resp, now); ok {
+			t.report(t.penalize(r.Context(), until))
+		}
+	}
+
+	return resp, nil
+}
+
+// penalize blocks every limiter of the transport able to be blocked until the given time
+func (t *Transport) penalize(ctx context.Context, until time.Time) error {
+	var firstErr error
+
+	limiters := []any{t.opts.Limiter}
+	for _, h := range t.opts.Usage {
+		limiters = append(limiters, h.Limiter)
+	}
+
+	for _, l := range limiters {
+		if p, ok := l.(penalizer); ok {
+			if err := p.Penalize(ctx, until); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func (t *Transport) report(err error) {
+	if err != nil && t.opts.OnUsageError != nil {
+		t.opts.OnUsageError(err)
+	}
+}
+
+// Wait consumes tokens from limiter, waiting on the clock for as long as the limiter tells every time it is
+// exceeded, until they are consumed or the context is done. Other errors of the limiter are returned at once.
+func Wait(ctx context.Context, limiter tokenLimiter, tokens int64, clock timerClock) (Result, error) {
+	for {
+		res, err := limiter.Try(ctx, tokens)
+		if !errors.Is(err, ErrRateLimitExceeded) {
+			return res, err
+		}
+
+		if err := sleep(ctx, clock, max(res.TimeToWait, minRetryWait)); err != nil {
+			return res, err
+		}
+	}
+}
+
+// sleep waits on the clock for duration d, or until the context is done
+func sleep(ctx context.Context, clock timerClock, d time.Duration) error {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// closeRequestBody closes the body of r, as round trippers must do even on errors
+func closeRequestBody(r *http.Request) {
+	if r.Body != nil {
+		_ = r.Body.Close()
+	}
+}
+
+// NewTransport returns a new instance of Transport, to be set as the Transport of an http.Client
+func NewTransport(opts TransportOpts) *Transport {
+	if opts.Base == nil {
+		opts.Base = http.DefaultTransport
+	}
+
+	if opts.Weight == nil {
+		opts.Weight = func(*http.Request) int64 { return 1 }
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = NewClock()
+	}
+
+	return &Transport{opts: opts}
+}
@@ -0,0 +1,156 @@
+package pacemaker_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sonirico/pacemaker"
+)
+
+func TestTransport(t *testing.T) {
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	var served int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&served, 1)
+	}))
+	t.Cleanup(srv.Close)
+
+	limiter := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+			Capacity: 3,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       pacemaker.NewFixedTruncatedWindowMemoryStorage(),
+		}),
+	)
+
+	cli := &http.Client{Transport: pacemaker.NewTransport(pacemaker.TransportOpts{
+		Limiter: &limiter,
+		Weight: func(r *http.Request) int64 {
+			n, _ := strconv.ParseInt(r.URL.Query().Get("weight"), 10, 64)
+			return n
+		},
+		Clock: clock,
+	})}
+
+	get := func(ctx context.Context, weight int) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?weight="+strconv.Itoa(weight), nil)
+		resp, err := cli.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	ctx := context.Background()
+
+	if err := get(ctx, 2); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- get(ctx, 2) }()
+
+	// The request waits for the next window
+	clock.BlockUntil(1)
+
+	if n := atomic.LoadInt64(&served); n != 1 {
+		t.Fatalf("unexpected served requests before the window ends, want 1, have %d", n)
+	}
+
+	clock.Forward(time.Minute)
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	if n := atomic.LoadInt64(&served); n != 2 {
+		t.Errorf("unexpected served requests, want 2, have %d", n)
+	}
+
+	// Waiting requests give up with their context
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() { done <- get(cancelled, 2) }()
+
+	clock.BlockUntil(1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error, want %v, have %v", context.Canceled, err)
+	}
+
+	if err := get(ctx, 4); !errors.Is(err, pacemaker.ErrTokensGreaterThanCapacity) {
+		t.Errorf("unexpected error, want %v, have %v", pacemaker.ErrTokensGreaterThanCapacity, err)
+	}
+}
+
+func TestTransport_WeightsForm(t *testing.T) {
+	const form = "limit=500"
+
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		received = string(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	weights, err := pacemaker.NewWeights(pacemaker.WeightsConfig{Rules: []pacemaker.WeightRule{{
+		Method: "POST",
+		Path:   "/",
+		Weight: 5,
+		Param:  "limit",
+		Tiers:  []pacemaker.WeightTier{{UpTo: 100, Weight: 5}, {UpTo: 500, Weight: 25}},
+	}}})
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	clock := pacemaker.NewMockClock(time.Date(2022, 02, 05, 10, 23, 0, 0, time.UTC))
+
+	limiter := pacemaker.NewTokenFixedWindowRateLimiter(
+		pacemaker.NewFixedTruncatedWindowRateLimiter(pacemaker.FixedTruncatedWindowArgs{
+			Capacity: 100,
+			Rate:     pacemaker.Rate{Amount: 1, Unit: time.Minute},
+			Clock:    clock,
+			DB:       pacemaker.NewFixedTruncatedWindowMemoryStorageWithClock(clock),
+		}),
+	)
+
+	cli := &http.Client{Transport: pacemaker.NewTransport(pacemaker.TransportOpts{
+		Limiter: &limiter,
+		Weight:  weights.Request,
+		Clock:   clock,
+	})}
+
+	// A body that cannot be got again, so that the transport reads it
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, io.NopCloser(strings.NewReader(form)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body := req.Body
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+	resp.Body.Close()
+
+	if req.Body != body {
+		t.Error("unexpected body of the request replaced by the transport")
+	}
+
+	if received != form {
+		t.Errorf("unexpected body received, want %q, have %q", form, received)
+	}
+
+	if r, _ := limiter.Dump(context.Background()); r.FreeSlots != 75 {
+		t.Errorf("unexpected free slots, want 75, have %d", r.FreeSlots)
+	}
+}
@@ -43,12 +43,24 @@ func min[T constraints.Integer](a, b T) T {
 	return a
 }
 
+func max[T constraints.Integer](a, b T) T {
+	if a < b {
+		return b
+	}
+	return a
+}
+
 // TimeGTE returns true if `target` is greater than or equals `from`
 func TimeGTE(from time.Time, target time.Time) bool {
 	// return target.After(from) || target.Equal(from)
 	return !target.Before(from)
 }
 
+// inWindow returns whether t falls in the window [start, end)
+func inWindow(t, start, end time.Time) bool {
+	return !t.Before(start) && t.Before(end)
+}
+
 func TimeFromNsStr(payload string) (t time.Time, err error) {
 	var n int64
 	n, err = strconv.ParseInt(payload, 10, 64)
@@ -0,0 +1,261 @@
+package pacemaker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type (
+	// WeightsConfig maps operations and endpoints to the tokens they cost, e.g.
+	//
+	//	{
+	//	  "default": 1,
+	//	  "rules": [
+	//	    {"operation": "place_order", "method": "POST", "path": "/api/v3/order", "weight": 1},
+	//	    {"method": "GET", "path": "/api/v3/depth", "weight": 5, "param": "limit", "tiers": [
+	//	      {"up_to": 100, "weight": 5},
+	//	      {"up_to": 500, "weight": 25},
+	//	      {"up_to": 1000, "weight": 50},
+	//	      {"up_to": 5000, "weight": 250}
+	//	    ]}
+	//	  ]
+	//	}
+	//
+	// makes the weight of the order book depend on its limit, as Binance does.
+	WeightsConfig struct {
+		// Default is the weight of operations and requests no rule matches. Defaults to 1 when unset, an explicit 0
+		// making them free.
+		Default *int64       `json:"default,omitempty"`
+		Rules   []WeightRule `json:"rules"`
+	}
+
+	// WeightRule matches an operation by name, a request by method and path, or both. Rules are matched in order,
+	// the first one matching wins.
+	WeightRule struct {
+		Operation string `json:"operation,omitempty"`
+		// Method matches any method if empty
+		Method string `json:"method,omitempty"`
+		// Path matches the path of requests segment by segment, * or {name} matching any segment, e.g.
+		// /api/v3/orders/{id}
+		Path string `json:"path,omitempty"`
+		// Weight is the cost of the operation, unless Tiers or Func say otherwise
+		Weight int64 `json:"weight"`
+		// Param names the parameter Tiers depend on, e.g. limit
+		Param string `json:"param,omitempty"`
+		// Tiers are the weights by the value of Param, the first one up to which the value is. Values over the
+		// last tier weight as it does. Weight applies when the parameter is missing or not an integer.
+		Tiers []WeightTier `json:"tiers,omitempty"`
+		// Func, if set, returns the weight from the parameters of the operation, overriding Weight and Tiers
+		Func func(params url.Values) int64 `json:"-"`
+	}
+
+	WeightTier struct {
+		UpTo   int64 `json:"up_to"`
+		Weight int64 `json:"weight"`
+	}
+
+	// Weights is a registry of the tokens every operation or endpoint costs, to consume from a
+	// TokenFixedWindowRateLimiter. Its Request method is usable as TransportOpts.Weight and HTTPMiddlewareOpts.Cost.
+	Weights struct {
+		config        WeightsConfig
+		paths         [][]string
+		defaultWeight int64
+	}
+)
+
+// Operation returns the weight of the named operation given its parameters
+func (w *Weights) Operation(name string, params url.Values) int64 {
+	for _, rule := range w.config.Rules {
+		if rule.Operation != "" && rule.Operation == name {
+			return rule.weight(params)
+		}
+	}
+
+	return w.defaultWeight
+}
+
+// Request returns the weight of the request by its method and path, given its query parameters along the ones of
+// its body when it is a URL-encoded form of up to 64KiB. Larger bodies are not read past that, their weight being
+// given by the query parameters alone.
+func (w *Weights) Request(r *http.Request) int64 {
+	segments := pathSegments(r.URL.Path)
+
+	for i, rule := range w.config.Rules {
+		if rule.Path == "" || (rule.Method != "" && !strings.EqualFold(rule.Method, r.Method)) {
+			continue
+		}
+
+		if matchPath(w.paths[i], segments) {
+			return rule.weight(requestParams(r))
+		}
+	}
+
+	return w.defaultWeight
+}
+
+// maxWeightFormSize bounds how much of the body of requests is read for their parameters, so that clients cannot
+// make servers buffer large forms before being rate limited
+const maxWeightFormSize = 64 << 10
+
+// requestParams returns the query parameters of r, along the ones of its body when it is a URL-encoded form. The
+// body is read from GetBody when set, as outgoing requests must not be modified. Otherwise, what was read is put
+// back in front of the rest of the body, so that it is still handled as is.
+func requestParams(r *http.Request) url.Values {
+	params := r.URL.Query()
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return params
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return params
+	}
+
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+		return params
+	}
+
+	body := r.Body
+	if r.GetBody != nil {
+		b, err := r.GetBody()
+		if err != nil {
+			return params
+		}
+		defer b.Close()
+		body = b
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxWeightFormSize+1))
+
+	if r.GetBody == nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{Reader: io.MultiReader(bytes.NewReader(data), r.Body), Closer: r.Body}
+	}
+
+	if err != nil || len(data) > maxWeightFormSize {
+		return params
+	}
+
+	form, err := url.ParseQuery(string(data))
+	if err != nil {
+		return params
+	}
+
+	for k, vs := range form {
+		params[k] = append(params[k], vs...)
+	}
+
+	return params
+}
+
+func (r WeightRule) weight(params url.Values) int64 {
+	if r.Func != nil {
+		return r.Func(params)
+	}
+
+	if r.Param == "" || len(r.Tiers) == 0 {
+		return r.Weight
+	}
+
+	value, err := strconv.ParseInt(params.Get(r.Param), 10, 64)
+	if err != nil {
+		return r.Weight
+	}
+
+	for _, tier := range r.Tiers {
+		if value <= tier.UpTo {
+			return tier.Weight
+		}
+	}
+
+	return r.Tiers[len(r.Tiers)-1].Weight
+}
+
+func (r WeightRule) validate(i int) error {
+	if r.Operation == "" && r.Path == "" {
+		return fmt.Errorf("weights: rule %d must match an operation or a path", i)
+	}
+
+	name := r.Operation
+	if name == "" {
+		name = strings.TrimSpace(r.Method + " " + r.Path)
+	}
+
+	if r.Weight < 0 {
+		return fmt.Errorf("weights: weight of %q cannot be negative", name)
+	}
+
+	if len(r.Tiers) > 0 && r.Param == "" {
+		return fmt.Errorf("weights: tiers of %q need a param", name)
+	}
+
+	for i, tier := range r.Tiers {
+		if tier.Weight < 0 {
+			return fmt.Errorf("weights: tier weights of %q cannot be negative", name)
+		}
+
+		if i > 0 && tier.UpTo <= r.Tiers[i-1].UpTo {
+			return fmt.Errorf("weights: tiers of %q must be sorted by up_to", name)
+		}
+	}
+
+	return nil
+}
+
+func pathSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// matchPath returns whether the segments of a path match the ones of a pattern
+func matchPath(pattern, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if p == "*" || (strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}")) {
+			continue
+		}
+
+		if p != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewWeights returns a new instance of Weights from its config, or an error if any of its rules is invalid
+func NewWeights(config WeightsConfig) (*Weights, error) {
+	w := &Weights{config: config, paths: make([][]string, len(config.Rules)), defaultWeight: 1}
+
+	if config.Default != nil {
+		if *config.Default < 0 {
+			return nil, fmt.Errorf("weights: default weight cannot be negative")
+		}
+
+		w.defaultWeight = *config.Default
+	}
+
+	for i, rule := range config.Rules {
+		if err := rule.validate(i); err != nil {
+			return nil, err
+		}
+
+		if rule.Path != "" {
+			w.paths[i] = pathSegments(rule.Path)
+		}
+	}
+
+	return w, nil
+}
@@ -0,0 +1,235 @@
+package pacemaker_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sonirico/pacemaker"
+)
+
+const weightsConfig = `{
+  "default": 2,
+  "rules": [
+    {"operation": "place_order", "method": "POST", "path": "/api/v3/order", "weight": 1},
+    {"method": "GET", "path": "/api/v3/depth", "weight": 5, "param": "limit", "tiers": [
+      {"up_to": 100, "weight": 5},
+      {"up_to": 500, "weight": 25},
+      {"up_to": 1000, "weight": 50},
+      {"up_to": 5000, "weight": 250}
+    ]},
+    {"path": "/api/v3/orders/{id}", "weight": 4},
+    {"method": "PUT", "path": "/api/v3/batch", "weight": 5, "param": "limit", "tiers": [
+      {"up_to": 100, "weight": 5},
+      {"up_to": 500, "weight": 25}
+    ]},
+    {"operation": "cancel_all", "weight": 0}
+  ]
+}`
+
+func newWeights(t *testing.T) *pacemaker.Weights {
+	t.Helper()
+
+	var config pacemaker.WeightsConfig
+	if err := json.Unmarshal([]byte(weightsConfig), &config); err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	config.Rules = append(config.Rules, pacemaker.WeightRule{
+		Operation: "ticker",
+		Func: func(params url.Values) int64 {
+			if params.Get("symbol") == "" {
+				return 40
+			}
+			return 1
+		},
+	})
+
+	w, err := pacemaker.NewWeights(config)
+	if err != nil {
+		t.Fatalf("unexpected error, want none, have %v", err)
+	}
+
+	return w
+}
+
+func TestWeights_Request(t *testing.T) {
+	w := newWeights(t)
+
+	tests := []struct {
+		method         string
+		target         string
+		form           string
+		expectedWeight int64
+	}{
+		{method: "POST", target: "/api/v3/order", expectedWeight: 1},
+		{method: "DELETE", target: "/api/v3/order", expectedWeight: 2},
+		{method: "GET", target: "/api/v3/depth", expectedWeight: 5},
+		{method: "GET", target: "/api/v3/depth?limit=100", expectedWeight: 5},
+		{method: "GET", target: "/api/v3/depth?limit=101", expectedWeight: 25},
+		{method: "GET", target: "/api/v3/depth?limit=1000", expectedWeight: 50},
+		{method: "GET", target: "/api/v3/depth?limit=10000", expectedWeight: 250},
+		{method: "GET", target: "/api/v3/depth?limit=all", expectedWeight: 5},
+		{method: "GET", target: "/api/v3/orders/42", expectedWeight: 4},
+		{method: "DELETE", target: "/api/v3/orders/42/", expectedWeight: 4},
+		{method: "GET", target: "/api/v3/orders/42/fills", expectedWeight: 2},
+		{method: "GET", target: "/api/v3/time", expectedWeight: 2},
+		{method: "PUT", target: "/api/v3/batch", form: "limit=500", expectedWeight: 25},
+		{method: "PUT", target: "/api/v3/batch?limit=10", form: "side=BUY", expectedWeight: 5},
+		{method: "PUT", target: "/api/v3/batch", expectedWeight: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			var body io.Reader
+			if test.form != "" {
+				body = strings.NewReader(test.form)
+			}
+
+			r := httptest.NewRequest(test.method, test.target, body)
+			if test.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+
+			if weight := w.Request(r); weight != test.expectedWeight {
+				t.Errorf("unexpected weight, want %d, have %d", test.expectedWeight, weight)
+			}
+
+			if test.form == "" {
+				return
+			}
+
+			if data, _ := io.ReadAll(r.Body); string(data) != test.form {
+				t.Errorf("unexpected body, want %q, have %q", test.form, data)
+			}
+		})
+	}
+}
+
+func TestWeights_RequestBody(t *testing.T) {
+	w := newWeights(t)
+
+	large := "limit=500&pad=" + strings.Repeat("x", 64<<10)
+
+	tests := []struct {
+		name           string
+		body           string
+		getBody        bool
+		expectedWeight int64
+	}{
+		{name: "form", body: "limit=500", expectedWeight: 25},
+		{name: "form read again", body: "limit=500", getBody: true, expectedWeight: 25},
+		{name: "form too large", body: large, expectedWeight: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/api/v3/batch", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if test.getBody {
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(test.body)), nil
+				}
+			}
+
+			body := r.Body
+
+			if weight := w.Request(r); weight != test.expectedWeight {
+				t.Errorf("unexpected weight, want %d, have %d", test.expectedWeight, weight)
+			}
+
+			if test.getBody && r.Body != body {
+				t.Error("unexpected body replaced, want the one of the request")
+			}
+
+			if data, _ := io.ReadAll(r.Body); string(data) != test.body {
+				t.Errorf("unexpected body of %d bytes, want the %d bytes sent", len(data), len(test.body))
+			}
+		})
+	}
+}
+
+func TestWeights_Default(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		expectedWeight int64
+	}{
+		{name: "unset", config: `{"rules": []}`, expectedWeight: 1},
+		{name: "zero", config: `{"default": 0, "rules": []}`, expectedWeight: 0},
+		{name: "set", config: `{"default": 3, "rules": []}`, expectedWeight: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var config pacemaker.WeightsConfig
+			if err := json.Unmarshal([]byte(test.config), &config); err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			w, err := pacemaker.NewWeights(config)
+			if err != nil {
+				t.Fatalf("unexpected error, want none, have %v", err)
+			}
+
+			if weight := w.Operation("unknown", nil); weight != test.expectedWeight {
+				t.Errorf("unexpected weight, want %d, have %d", test.expectedWeight, weight)
+			}
+		})
+	}
+}
+
+func TestWeights_Operation(t *testing.T) {
+	w := newWeights(t)
+
+	tests := []struct {
+		operation      string
+		params         url.Values
+		expectedWeight int64
+	}{
+		{operation: "place_order", expectedWeight: 1},
+		{operation: "cancel_all", expectedWeight: 0},
+		{operation: "ticker", params: url.Values{"symbol": {"BTCUSDT"}}, expectedWeight: 1},
+		{operation: "ticker", expectedWeight: 40},
+		{operation: "unknown", expectedWeight: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.operation, func(t *testing.T) {
+			if weight := w.Operation(test.operation, test.params); weight != test.expectedWeight {
+				t.Errorf("unexpected weight, want %d, have %d", test.expectedWeight, weight)
+			}
+		})
+	}
+}
+
+func TestNewWeights_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule pacemaker.WeightRule
+	}{
+		{name: "matches nothing", rule: pacemaker.WeightRule{Weight: 1}},
+		{name: "negative weight", rule: pacemaker.WeightRule{Operation: "op", Weight: -1}},
+		{name: "tiers without param", rule: pacemaker.WeightRule{
+			Operation: "op",
+			Tiers:     []pacemaker.WeightTier{{UpTo: 1, Weight: 1}},
+		}},
+		{name: "unsorted tiers", rule: pacemaker.WeightRule{
+			Operation: "op",
+			Param:     "limit",
+			Tiers:     []pacemaker.WeightTier{{UpTo: 10, Weight: 1}, {UpTo: 5, Weight: 2}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := pacemaker.NewWeights(pacemaker.WeightsConfig{Rules: []pacemaker.WeightRule{test.rule}}); err == nil {
+				t.Error("expected error, have none")
+			}
+		})
+	}
+}